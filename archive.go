@@ -0,0 +1,143 @@
+package opt
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// readCloser reads from Reader and closes all underlying readers
+type readCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (rc *readCloser) Close() error {
+	var err error
+	for i := len(rc.closers) - 1; i >= 0; i-- {
+		if cerr := rc.closers[i].Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// SplitArchivePath splits path of file inside archive, e.g. bundle.zip#app/config.hjson,
+// into archive path and name of the file inside archive. Supported archives are
+// .zip, .tar, .tar.gz and .tgz. If the path does not refer to archive, name is empty.
+func SplitArchivePath(filePath string) (string, string) {
+	i := strings.LastIndex(filePath, "#")
+	if i < 0 || archiveType(filePath[:i]) == "" {
+		return filePath, ""
+	}
+	return filePath[:i], filePath[i+1:]
+}
+
+// archiveType returns archive type from file extension
+func archiveType(name string) string {
+	name = strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return "zip"
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return "tgz"
+	case strings.HasSuffix(name, ".tar"):
+		return "tar"
+	}
+	return ""
+}
+
+// openFile opens file, file inside archive or gzip compressed file.
+// Returned name, without .gz extension, is used for detecting format.
+func openFile(filePath string) (io.ReadCloser, string, error) {
+	archive, name := SplitArchivePath(filePath)
+
+	var rc io.ReadCloser
+	var err error
+	if name == "" {
+		name = filePath
+		rc, err = os.Open(filePath)
+	} else {
+		rc, err = openArchive(archive, name)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	// gzip compressed single file
+	if strings.EqualFold(path.Ext(name), ".gz") {
+		gz, err := gzip.NewReader(rc)
+		if err != nil {
+			rc.Close()
+			return nil, "", err
+		}
+		rc = &readCloser{Reader: gz, closers: []io.Closer{rc, gz}}
+		name = name[:len(name)-len(".gz")]
+	}
+
+	return rc, name, nil
+}
+
+// openArchive opens file with given name inside archive
+func openArchive(archive, name string) (io.ReadCloser, error) {
+	name = path.Clean(strings.TrimLeft(name, "/"))
+	notFound := errors.Errorf("file %s not found in archive %s", name, archive)
+
+	if archiveType(archive) == "zip" {
+		zr, err := zip.OpenReader(archive)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range zr.File {
+			if path.Clean(f.Name) != name {
+				continue
+			}
+			r, err := f.Open()
+			if err != nil {
+				zr.Close()
+				return nil, err
+			}
+			return &readCloser{Reader: r, closers: []io.Closer{zr, r}}, nil
+		}
+		zr.Close()
+		return nil, notFound
+	}
+
+	// tar, optionally gzip compressed
+	f, err := os.Open(archive)
+	if err != nil {
+		return nil, err
+	}
+	rc := &readCloser{Reader: f, closers: []io.Closer{f}}
+	if archiveType(archive) == "tgz" {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		rc.Reader = gz
+		rc.closers = append(rc.closers, gz)
+	}
+	tr := tar.NewReader(rc.Reader)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			rc.Close()
+			return nil, notFound
+		}
+		if err != nil {
+			rc.Close()
+			return nil, err
+		}
+		if hdr.Typeflag == tar.TypeReg && path.Clean(hdr.Name) == name {
+			rc.Reader = tr
+			return rc, nil
+		}
+	}
+}
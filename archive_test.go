@@ -0,0 +1,94 @@
+package opt
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const archiveConfig = `{"log": {"level": "debug"}}`
+
+func writeTestArchives(t *testing.T, dir string) {
+	// zip
+	f, err := os.Create(filepath.Join(dir, "bundle.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	w, _ := zw.Create("app/config.json")
+	w.Write([]byte(archiveConfig))
+	zw.Close()
+	f.Close()
+
+	// tar.gz
+	f, err = os.Create(filepath.Join(dir, "bundle.tar.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	tw.WriteHeader(&tar.Header{Name: "./README", Mode: 0644, Size: 2, Typeflag: tar.TypeReg})
+	tw.Write([]byte("hi"))
+	tw.WriteHeader(&tar.Header{Name: "./app/config.json", Mode: 0644, Size: int64(len(archiveConfig)), Typeflag: tar.TypeReg})
+	tw.Write([]byte(archiveConfig))
+	tw.Close()
+	gz.Close()
+	f.Close()
+
+	// gzip
+	f, err = os.Create(filepath.Join(dir, "config.json.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz = gzip.NewWriter(f)
+	gz.Write([]byte(archiveConfig))
+	gz.Close()
+	f.Close()
+}
+
+func TestFromFileArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "opt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeTestArchives(t, dir)
+
+	for _, name := range []string{"bundle.zip#app/config.json", "bundle.tar.gz#app/config.json", "config.json.gz"} {
+		op, err := FromFile(filepath.Join(dir, name), FormatAuto)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if v := op.GetString("log.level", ""); v != "debug" {
+			t.Fatalf("%s: expecting debug, got %s", name, v)
+		}
+	}
+
+	if _, err := FromFile(filepath.Join(dir, "bundle.zip#missing.json"), FormatAuto); err == nil {
+		t.Fatalf("Missing file in archive must return error")
+	}
+	if err := ToFile(New(), filepath.Join(dir, "bundle.zip#app/config.json"), FormatAuto); err == nil {
+		t.Fatalf("Writing to archive must return error")
+	}
+}
+
+func TestSplitArchivePath(t *testing.T) {
+	tests := []struct {
+		path, archive, name string
+	}{
+		{"bundle.zip#app/config.hjson", "bundle.zip", "app/config.hjson"},
+		{"conf/bundle.tgz#config.json", "conf/bundle.tgz", "config.json"},
+		{"conf/#1.json", "conf/#1.json", ""},
+		{"config.json", "config.json", ""},
+	}
+	for _, tt := range tests {
+		archive, name := SplitArchivePath(tt.path)
+		if archive != tt.archive || name != tt.name {
+			t.Fatalf("%s: expecting %s %s, got %s %s", tt.path, tt.archive, tt.name, archive, name)
+		}
+	}
+}
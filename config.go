@@ -1,8 +1,12 @@
 package opt
 
 import (
-	"errors"
+	"context"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
 )
 
 type configurableItem struct {
@@ -15,16 +19,66 @@ type Configurable interface {
 	Configure(op *Options, first bool)
 }
 
-// Configurator stores application wide configuration
+// ValidatingConfigurable is Configurable which may reject new configuration.
+// On reload, Validate is called for every changed section first and Apply is
+// called only if all sections are valid. If Apply fails, sections which are
+// already applied are rolled back to previous configuration.
+// Configure is still used for the first configuration.
+type ValidatingConfigurable interface {
+	Configurable
+	Validate(op *Options) error
+	Apply(op *Options) error
+}
+
+// configure item with new section
+func (item configurableItem) configure(oldOpt, newOpt *Options) error {
+	switch c := item.conf.(type) {
+	case ValidatingConfigurable:
+		return c.Apply(newOpt)
+	case ConfigurableDiff:
+		c.ConfigureDiff(oldOpt, newOpt, Diff(oldOpt, newOpt))
+	default:
+		c.Configure(newOpt, false)
+	}
+	return nil
+}
+
+// Configurator stores application wide configuration.
+// Loaded configuration is kept as frozen snapshot which is swapped atomically
+// on reload, so Get does not take any lock.
 type Configurator struct {
 	mu      sync.RWMutex
 	conn    Connector
-	lastCfg *Options
+	lastCfg atomic.Value
 	items   []configurableItem
+	subMu   sync.Mutex
+	subs    []*Subscription
+
+	history      []HistoryEntry
+	historySize  int
+	writeThrough bool
+	revision     string
+
+	errMu      sync.Mutex
+	logger     Logger
+	errHandler func(err error)
+	errs       chan error
+
+	statMu             sync.Mutex
+	stats              Stats
+	sourceFailingSince time.Time
+
+	throttle throttle
 }
 
 // NewConfigurator open configuration with given driver and property
 func NewConfigurator(driver string, prop *Options) (*Configurator, error) {
+	return NewConfiguratorContext(context.Background(), driver, prop)
+}
+
+// NewConfiguratorContext open configuration with given driver and property.
+// The context is used for connecting and loading the initial configuration.
+func NewConfiguratorContext(ctx context.Context, driver string, prop *Options) (*Configurator, error) {
 	// load the driver
 	drv := DriverFor(driver)
 	if drv == nil {
@@ -32,28 +86,72 @@ func NewConfigurator(driver string, prop *Options) (*Configurator, error) {
 	}
 
 	// create configurator
-	cfg := Configurator{}
-	conn, err := drv.Connect(cfg.sourceChanged, prop)
+	cfg := &Configurator{
+		historySize: DefaultHistorySize,
+		logger:      stdLogger{},
+		stats:       Stats{Driver: driver},
+	}
+	conn, err := connectContext(ctx, drv, cfg.sourceChanged, prop)
 	if err != nil {
 		return nil, err
 	}
-	cfg.lastCfg, err = conn.Load()
+	if r, ok := conn.(ErrorReporter); ok {
+		r.SetErrorHandler(cfg.reportError)
+	}
+	newCfg, rev, err := cfg.load(ctx, conn)
 	if err != nil {
 		conn.Close()
 		return nil, err
 	}
+	cfg.setConfig(newCfg)
+	cfg.revision = rev
+	cfg.recordReload(nil)
 	cfg.conn = conn
 
-	return &cfg, nil
+	return cfg, nil
+}
+
+// config returns current configuration or nil if not loaded
+func (cfg *Configurator) config() *Options {
+	op, _ := cfg.lastCfg.Load().(*Options)
+	return op
+}
+
+// setConfig replaces current configuration with frozen snapshot of op
+func (cfg *Configurator) setConfig(op *Options) {
+	if op != nil {
+		op = op.Snapshot()
+		cfg.lastCfg.Store(op)
+		cfg.addHistory(op)
+	}
 }
 
 func (cfg *Configurator) sourceChanged(f int) error {
+	// reload now or let the reload policy schedule it
+	scheduled := cfg.throttle.schedule(func() {
+		if err := cfg.reload(); err != nil {
+			cfg.reportError(err)
+		}
+	})
+	if scheduled {
+		return nil
+	}
+	return cfg.reload()
+}
+
+func (cfg *Configurator) reload() (err error) {
 	// if new configuration is available, load it.
 	// if the configuration is changed, reconfigure all registered configurable
 	cfg.mu.Lock()
 	defer cfg.mu.Unlock()
+	defer func() {
+		cfg.recordReload(err)
+	}()
 
-	newCfg, err := cfg.conn.Load()
+	if cfg.conn == nil {
+		return errors.New("configurator is closed")
+	}
+	newCfg, rev, err := cfg.load(context.Background(), cfg.conn)
 	if err != nil {
 		return err
 	}
@@ -61,75 +159,210 @@ func (cfg *Configurator) sourceChanged(f int) error {
 		return errors.New("loaded configuration return <nil>")
 	}
 
-	// if configuration for given section is changed,
-	// broadcast the change to `Configurable` item
-	changed := false
+	// revision is kept if the configuration is rejected, so Store does not
+	// overwrite the rejected change
+	if err := cfg.apply(newCfg, false); err != nil {
+		return err
+	}
+	cfg.revision = rev
+
+	return nil
+}
+
+// itemChange is configuration change of registered item
+type itemChange struct {
+	item           configurableItem
+	oldOpt, newOpt *Options
+}
+
+// changedItems returns registered items whose section is changed,
+// all items if all set to true
+func (cfg *Configurator) changedItems(newCfg *Options, all bool) []itemChange {
+	lastCfg := cfg.config()
+	changes := []itemChange{}
 	for _, item := range cfg.items {
 		newOpt := newCfg.Get(item.section)
-		oldOpt := cfg.lastCfg.Get(item.section)
-		if !newOpt.EqualTo(oldOpt) {
-			changed = true
-			item.conf.Configure(newOpt, false)
+		oldOpt := New().Freeze()
+		if lastCfg != nil {
+			oldOpt = lastCfg.Get(item.section)
 		}
+		if !all && newOpt.EqualTo(oldOpt) {
+			continue
+		}
+		changes = append(changes, itemChange{item: item, oldOpt: oldOpt, newOpt: newOpt})
 	}
-	if changed {
-		cfg.lastCfg = newCfg
+	return changes
+}
+
+// validate changed sections with ValidatingConfigurable items
+func validateItems(changes []itemChange) error {
+	for _, c := range changes {
+		if vc, ok := c.item.conf.(ValidatingConfigurable); ok {
+			if err := vc.Validate(c.newOpt); err != nil {
+				return errors.Wrapf(err, "invalid configuration for section %q", c.item.section)
+			}
+		}
 	}
+	return nil
+}
+
+// validate new configuration without applying it
+func (cfg *Configurator) validate(newCfg *Options) error {
+	return validateItems(cfg.changedItems(newCfg.Snapshot(), false))
+}
+
+// apply validates and applies new configuration to registered items, then
+// replaces the current configuration. If all set to false, only items whose
+// section is changed are configured. On error current configuration is kept.
+func (cfg *Configurator) apply(newCfg *Options, all bool) error {
+	// if configuration for given section is changed,
+	// broadcast the change to `Configurable` item
+	newCfg = newCfg.Snapshot()
+	lastCfg := cfg.config()
+	changes := cfg.changedItems(newCfg, all)
+
+	// validate all sections before applying
+	if err := validateItems(changes); err != nil {
+		return err
+	}
+
+	// apply, on error rollback already applied sections
+	for i, c := range changes {
+		if err := c.item.configure(c.oldOpt, c.newOpt); err != nil {
+			for k := i - 1; k >= 0; k-- {
+				changes[k].item.configure(changes[k].newOpt, changes[k].oldOpt)
+			}
+			return errors.Wrapf(err, "failed to apply configuration for section %q", c.item.section)
+		}
+	}
+	cfg.setConfig(newCfg)
+	cfg.notify(lastCfg, newCfg)
 
 	return nil
 }
 
 // Valid returns true if connector is set and configuration loaded
 func (cfg *Configurator) Valid() bool {
-	return cfg.conn != nil && cfg.lastCfg != nil
-}
-
-// Get return configuration for given key
-func (cfg *Configurator) Get(key string) *Options {
 	cfg.mu.RLock()
 	defer cfg.mu.RUnlock()
 
-	if cfg.lastCfg != nil {
-		return cfg.lastCfg.Get(key)
+	return cfg.valid()
+}
+
+// valid is Valid for caller holding the lock
+func (cfg *Configurator) valid() bool {
+	return cfg.conn != nil && cfg.config() != nil
+}
+
+// Get return configuration for given key. The returned options shares data
+// with current configuration, modification is copy-on-write, so Set on it
+// does not affect the configuration.
+func (cfg *Configurator) Get(key string) *Options {
+	if lastCfg := cfg.config(); lastCfg != nil {
+		return lastCfg.Get(key)
 	}
 	return New()
 }
 
 // Store save configuration
 func (cfg *Configurator) Store() error {
+	return cfg.StoreContext(context.Background())
+}
+
+// StoreContext save configuration, the context is passed to the connector.
+// If the connector is VersionedConnector and the source is changed since
+// last load, ErrConflict is returned.
+func (cfg *Configurator) StoreContext(ctx context.Context) error {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+
+	if cfg.valid() {
+		return cfg.store(ctx, cfg.config())
+	}
+	return nil
+}
+
+// Revision returns source revision of last loaded or stored configuration,
+// empty if the connector is not VersionedConnector
+func (cfg *Configurator) Revision() string {
 	cfg.mu.RLock()
 	defer cfg.mu.RUnlock()
 
-	if cfg.Valid() {
-		return cfg.conn.Store(cfg.lastCfg)
+	return cfg.revision
+}
+
+// load configuration and its revision from connector
+func (cfg *Configurator) load(ctx context.Context, conn Connector) (*Options, string, error) {
+	start := time.Now()
+	defer func() {
+		cfg.recordLatency(true, time.Since(start))
+	}()
+
+	if vc, ok := conn.(VersionedConnector); ok {
+		return vc.LoadVersion(ctx)
 	}
-	return nil
+	op, err := loadContext(ctx, conn)
+	return op, "", err
+}
+
+// store configuration to connector, compare and swap the revision if supported
+func (cfg *Configurator) store(ctx context.Context, op *Options) error {
+	start := time.Now()
+	defer func() {
+		cfg.recordLatency(false, time.Since(start))
+	}()
+
+	if vc, ok := cfg.conn.(VersionedConnector); ok {
+		rev, err := vc.StoreVersion(ctx, op, cfg.revision)
+		if err != nil {
+			return err
+		}
+		cfg.revision = rev
+		return nil
+	}
+	return storeContext(ctx, cfg.conn, op)
 }
 
 // Load configuration from underlying source and
-// if configure set to true, configure all registered objects
+// if configure set to true, configure all registered objects.
+// If any object rejects the configuration, current configuration is kept.
 func (cfg *Configurator) Load(configure bool) error {
+	return cfg.LoadContext(context.Background(), configure)
+}
+
+// LoadContext is Load with context passed to the connector
+func (cfg *Configurator) LoadContext(ctx context.Context, configure bool) (err error) {
 	cfg.mu.Lock()
 	defer cfg.mu.Unlock()
 
 	if cfg.conn != nil {
-		newCfg, err := cfg.conn.Load()
+		defer func() {
+			cfg.recordReload(err)
+		}()
+		newCfg, rev, err := cfg.load(ctx, cfg.conn)
 		if err != nil {
 			return err
 		}
-		cfg.lastCfg = newCfg
 		if configure {
-			cfg.Configure()
+			if err := cfg.apply(newCfg, true); err != nil {
+				return err
+			}
+			cfg.revision = rev
+			return nil
 		}
+		lastCfg := cfg.config()
+		cfg.setConfig(newCfg)
+		cfg.revision = rev
+		cfg.notify(lastCfg, newCfg)
 	}
 	return nil
 }
 
 // Configure all registered configurable
 func (cfg *Configurator) Configure() {
-	if cfg.lastCfg != nil {
+	if lastCfg := cfg.config(); lastCfg != nil {
 		for _, item := range cfg.items {
-			item.conf.Configure(cfg.lastCfg.Get(item.section), false)
+			item.conf.Configure(lastCfg.Get(item.section), false)
 		}
 	}
 }
@@ -148,17 +381,40 @@ func (cfg *Configurator) Register(section string, c Configurable) {
 
 	// append new client then reconfigure
 	cfg.items = append(cfg.items, configurableItem{section: section, conf: c})
-	if cfg.lastCfg != nil {
-		c.Configure(cfg.lastCfg, true)
+	if lastCfg := cfg.config(); lastCfg != nil {
+		c.Configure(lastCfg.view(), true)
 	}
 }
 
 // Close configurator
 func (cfg *Configurator) Close() error {
-	if cfg.conn != nil {
-		c := cfg.conn
-		cfg.conn = nil
+	cfg.throttle.stop()
+
+	cfg.mu.Lock()
+	c := cfg.conn
+	cfg.conn = nil
+	cfg.mu.Unlock()
+
+	// connector is closed without the lock, its watching goroutine
+	// may wait for the lock in sourceChanged
+	if c != nil {
 		return c.Close()
 	}
 	return nil
 }
+
+// CloseContext closes configurator, waiting for the connector to be closed
+// until the context is done.
+func (cfg *Configurator) CloseContext(ctx context.Context) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- cfg.Close()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,425 @@
+package opt
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// in-memory driver for testing Configurator
+type testDriver struct {
+	conn *testConnector
+}
+
+type testConnector struct {
+	Reporter
+	mu      sync.Mutex
+	handler func(f int) error
+	text    string
+	stored  *Options
+}
+
+func (td *testDriver) Connect(h func(f int) error, prop *Options) (Connector, error) {
+	td.conn.handler = h
+	return td.conn, nil
+}
+
+func (tc *testConnector) Load() (*Options, error) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	return FromText(tc.text, FormatJSON)
+}
+
+func (tc *testConnector) Store(v *Options) error {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	tc.stored = v
+	return nil
+}
+
+func (tc *testConnector) Close() error {
+	return nil
+}
+
+// change source content and notify configurator
+func (tc *testConnector) change(text string) error {
+	tc.mu.Lock()
+	tc.text = text
+	tc.mu.Unlock()
+
+	return tc.handler(SourceModified)
+}
+
+func newTestConfigurator(tb testing.TB, text string) (*Configurator, *testConnector) {
+	unregisterAllDrivers()
+	conn := &testConnector{text: text}
+	Register("test", &testDriver{conn: conn})
+
+	cfg, err := NewConfigurator("test", New())
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return cfg, conn
+}
+
+type testConfigurable struct {
+	mu    sync.Mutex
+	count int
+	last  *Options
+}
+
+func (tc *testConfigurable) Configure(op *Options, first bool) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	tc.count++
+	tc.last = op
+}
+
+func TestConfigurator(t *testing.T) {
+	cfg, conn := newTestConfigurator(t, `{"log": {"level": "info"}, "db": {"port": 5432}}`)
+	defer cfg.Close()
+
+	logCfg := &testConfigurable{}
+	cfg.Register("log", logCfg)
+	if logCfg.count != 1 || logCfg.last.GetString("log.level", "") != "info" {
+		t.Fatalf("Configurable must receive whole configuration on register, got %v", logCfg.last)
+	}
+
+	// change other section, log must not be reconfigured
+	if err := conn.change(`{"log": {"level": "info"}, "db": {"port": 5433}}`); err != nil {
+		t.Fatal(err)
+	}
+	if logCfg.count != 1 {
+		t.Fatalf("Configurable must not be reconfigured, count %d", logCfg.count)
+	}
+	if v := cfg.Get("db").GetInt("port", 0); v != 5433 {
+		t.Fatalf("Expecting port 5433, got %d", v)
+	}
+
+	if err := conn.change(`{"log": {"level": "debug"}, "db": {"port": 5433}}`); err != nil {
+		t.Fatal(err)
+	}
+	if logCfg.count != 2 || logCfg.last.GetString("level", "") != "debug" {
+		t.Fatalf("Configurable must be reconfigured, got %v", logCfg.last)
+	}
+	// modification of returned section does not affect the configuration
+	logOpt := cfg.Get("log")
+	logOpt.Set("level", "warn")
+	if v := cfg.Get("log").GetString("level", ""); v != "debug" {
+		t.Fatalf("Configuration changed by Set on returned section, got %s", v)
+	}
+}
+
+// settingConfigurable modifies received options
+type settingConfigurable struct {
+	testConfigurable
+}
+
+func (sc *settingConfigurable) Configure(op *Options, first bool) {
+	op.Set("configured", true)
+	sc.testConfigurable.Configure(op, first)
+}
+
+func TestConfiguratorConfigureSet(t *testing.T) {
+	cfg, conn := newTestConfigurator(t, `{"log": {"level": "info"}}`)
+	defer cfg.Close()
+
+	sc := &settingConfigurable{}
+	cfg.Register("log", sc)
+	if err := conn.change(`{"log": {"level": "debug"}}`); err != nil {
+		t.Fatal(err)
+	}
+	if sc.count != 2 {
+		t.Fatalf("Expecting 2 configurations, got %d", sc.count)
+	}
+	if cfg.config().Exists("configured") || cfg.Get("log").Exists("configured") {
+		t.Fatalf("Set in Configure must not affect the configuration")
+	}
+}
+
+func BenchmarkConfiguratorGet(b *testing.B) {
+	cfg, _ := newTestConfigurator(b, `{"log": {"level": "info"}, "db": {"port": 5432}}`)
+	defer cfg.Close()
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			cfg.Get("log").GetString("level", "")
+		}
+	})
+}
+
+func BenchmarkConfiguratorGetReload(b *testing.B) {
+	cfg, conn := newTestConfigurator(b, `{"log": {"level": "info"}, "db": {"port": 5432}}`)
+	defer cfg.Close()
+
+	quit := make(chan bool)
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		texts := []string{
+			`{"log": {"level": "debug"}, "db": {"port": 5432}}`,
+			`{"log": {"level": "info"}, "db": {"port": 5432}}`,
+		}
+		for i := 0; ; i++ {
+			select {
+			case <-quit:
+				return
+			default:
+				conn.change(texts[i%2])
+			}
+		}
+	}()
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			cfg.Get("log").GetString("level", "")
+		}
+	})
+	b.StopTimer()
+
+	close(quit)
+	<-done
+}
+
+type testValidating struct {
+	testConfigurable
+	applied  []int
+	failPort int
+}
+
+func (tv *testValidating) Validate(op *Options) error {
+	if op.GetInt("port", 0) <= 0 {
+		return errors.New("invalid port")
+	}
+	return nil
+}
+
+func (tv *testValidating) Apply(op *Options) error {
+	port := op.GetInt("port", 0)
+	if port == tv.failPort {
+		return errors.New("port in use")
+	}
+	tv.applied = append(tv.applied, port)
+	return nil
+}
+
+func TestValidatingConfigurable(t *testing.T) {
+	cfg, conn := newTestConfigurator(t, `{"a": {"port": 1}, "b": {"port": 2}}`)
+	defer cfg.Close()
+
+	a := &testValidating{}
+	b := &testValidating{failPort: 20}
+	cfg.Register("a", a)
+	cfg.Register("b", b)
+
+	// validation failed, nothing applied
+	if err := conn.change(`{"a": {"port": 10}, "b": {"port": -1}}`); err == nil {
+		t.Fatalf("Invalid configuration must be rejected")
+	}
+	if len(a.applied) != 0 || cfg.Get("a").GetInt("port", 0) != 1 {
+		t.Fatalf("Configuration must not be applied, got %v", a.applied)
+	}
+
+	// apply failed, a is rolled back
+	if err := conn.change(`{"a": {"port": 10}, "b": {"port": 20}}`); err == nil {
+		t.Fatalf("Apply error must be returned")
+	}
+	if len(a.applied) != 2 || a.applied[0] != 10 || a.applied[1] != 1 {
+		t.Fatalf("Section a must be rolled back, got %v", a.applied)
+	}
+	if cfg.Get("a").GetInt("port", 0) != 1 {
+		t.Fatalf("Current configuration must be kept")
+	}
+
+	if err := conn.change(`{"a": {"port": 10}, "b": {"port": 30}}`); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Get("b").GetInt("port", 0) != 30 {
+		t.Fatalf("Configuration must be applied")
+	}
+}
+
+func TestConfiguratorContext(t *testing.T) {
+	cfg, _ := newTestConfigurator(t, `{"log": {"level": "info"}}`)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := NewConfiguratorContext(ctx, "test", New()); err != context.Canceled {
+		t.Fatalf("Expecting context.Canceled, got %v", err)
+	}
+	if err := cfg.LoadContext(ctx, true); err != context.Canceled {
+		t.Fatalf("Expecting context.Canceled, got %v", err)
+	}
+	if err := cfg.StoreContext(ctx); err != context.Canceled {
+		t.Fatalf("Expecting context.Canceled, got %v", err)
+	}
+	if err := cfg.CloseContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConfiguratorUpdate(t *testing.T) {
+	cfg, _ := newTestConfigurator(t, `{"log": {"level": "info"}, "db": {"port": 5432}}`)
+	defer cfg.Close()
+
+	logCfg := &testConfigurable{}
+	dbCfg := &testConfigurable{}
+	cfg.Register("log", logCfg)
+	cfg.Register("db", dbCfg)
+	sub := cfg.Subscribe("log.level")
+
+	if err := cfg.Set("log.level", "debug"); err != nil {
+		t.Fatal(err)
+	}
+	if v := cfg.Get("log").GetString("level", ""); v != "debug" {
+		t.Fatalf("Expecting debug, got %s", v)
+	}
+	if logCfg.count != 2 || dbCfg.count != 1 {
+		t.Fatalf("Only log must be reconfigured, got %d %d", logCfg.count, dbCfg.count)
+	}
+	if c := <-sub.C; c.NewValue != "debug" {
+		t.Fatalf("Subscriber must be notified, got %+v", c)
+	}
+}
+
+type failingConnector struct {
+	testConnector
+}
+
+func (fc *failingConnector) Store(v *Options) error {
+	return errors.New("read-only source")
+}
+
+func TestConfiguratorUpdateWriteThrough(t *testing.T) {
+	cfg, conn := newTestConfigurator(t, `{"log": {"level": "info"}}`)
+	defer cfg.Close()
+	cfg.SetWriteThrough(true)
+
+	if err := cfg.Update(func(op *Options) {
+		op.Set("log.level", "debug")
+		op.Set("log.file", "app.log")
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if conn.stored == nil || conn.stored.GetString("log.file", "") != "app.log" {
+		t.Fatalf("Configuration must be stored")
+	}
+
+	// store failed, configuration is restored
+	// failed store must not be seen by components and subscribers
+	logCfg := &testConfigurable{}
+	cfg.Register("log", logCfg)
+	changes := 0
+	cfg.OnChange("log", func(c Change) {
+		changes++
+	})
+	cfg.conn = &failingConnector{}
+	if err := cfg.Set("log.level", "warn"); err == nil {
+		t.Fatalf("Store error must be returned")
+	}
+	if v := cfg.Get("log").GetString("level", ""); v != "debug" {
+		t.Fatalf("Configuration must be kept, got %s", v)
+	}
+	if logCfg.count != 1 || changes != 0 {
+		t.Fatalf("Update must not be applied, configured %d, changes %d", logCfg.count, changes)
+	}
+	cfg.conn = conn
+}
+
+// versioned in-memory connector, revision is incremented on every store
+type versionedConnector struct {
+	testConnector
+	rev int
+}
+
+func (vc *versionedConnector) LoadVersion(ctx context.Context) (*Options, string, error) {
+	op, err := vc.Load()
+	return op, strconv.Itoa(vc.rev), err
+}
+
+func (vc *versionedConnector) StoreVersion(ctx context.Context, v *Options, revision string) (string, error) {
+	if revision != "" && revision != strconv.Itoa(vc.rev) {
+		return "", ErrConflict
+	}
+	vc.rev++
+	vc.stored = v
+	return strconv.Itoa(vc.rev), nil
+}
+
+func TestConfiguratorStoreConflict(t *testing.T) {
+	cfg, _ := newTestConfigurator(t, `{"log": {"level": "info"}}`)
+	defer cfg.Close()
+
+	vc := &versionedConnector{}
+	vc.text = `{"log": {"level": "info"}}`
+	cfg.conn = vc
+	if err := cfg.Load(false); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Store(); err != nil {
+		t.Fatal(err)
+	}
+	if rev := cfg.Revision(); rev != "1" {
+		t.Fatalf("Expecting revision 1, got %s", rev)
+	}
+
+	// other instance stores configuration
+	vc.rev++
+	if err := cfg.Store(); err != ErrConflict {
+		t.Fatalf("Expecting ErrConflict, got %v", err)
+	}
+}
+
+func TestConfiguratorRejectedRevision(t *testing.T) {
+	cfg, _ := newTestConfigurator(t, `{"a": {"port": 1}}`)
+	defer cfg.Close()
+
+	vc := &versionedConnector{}
+	vc.text = `{"a": {"port": 1}}`
+	cfg.conn = vc
+	if err := cfg.Load(false); err != nil {
+		t.Fatal(err)
+	}
+	cfg.Register("a", &testValidating{})
+
+	// other instance stores invalid configuration, it is rejected
+	vc.text = `{"a": {"port": -1}}`
+	vc.rev++
+	if err := cfg.Load(true); err == nil {
+		t.Fatalf("Invalid configuration must be rejected")
+	}
+	if rev := cfg.Revision(); rev != "0" {
+		t.Fatalf("Revision of rejected configuration must not be kept, got %s", rev)
+	}
+	if err := cfg.Store(); err != ErrConflict {
+		t.Fatalf("Store must not overwrite rejected change, got %v", err)
+	}
+}
+
+func TestConfiguratorValidClose(t *testing.T) {
+	cfg, _ := newTestConfigurator(t, `{"a": 1}`)
+	if !cfg.Valid() {
+		t.Fatalf("Configurator must be valid after load")
+	}
+
+	// Valid may be called concurrently with Close
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			cfg.Valid()
+		}
+	}()
+	cfg.Close()
+	<-done
+	if cfg.Valid() {
+		t.Fatalf("Closed configurator must not be valid")
+	}
+}
@@ -1,8 +1,10 @@
 package db
 
 import (
+	"context"
+	"crypto/ed25519"
+	"database/sql"
 	"errors"
-	"log"
 	"strings"
 	"sync"
 
@@ -17,6 +19,20 @@ type driverOptions struct {
 	StoreQuery string `json:"storeQuery"`
 	DSN        string `json:"dsn"`
 	CronSpec   string `json:"cronSpec"`
+	PublicKey  string `json:"publicKey"`
+	Versioned  bool   `json:"versioned"`
+}
+
+// configRow is a row selected by loadQuery
+type configRow struct {
+	config    string
+	signature string
+	version   string
+}
+
+// rowQueryer is implemented by both database and transaction
+type rowQueryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
 }
 
 //dbx driver configuration
@@ -25,12 +41,14 @@ type dbDriver struct {
 
 //dbx connector
 type dbConnector struct {
+	opt.Reporter
 	mu       sync.Mutex
 	handler  func(f int) error
 	op       driverOptions
-	lastConf string
+	lastHash string
 	c        *cron.Cron
 	dbx      *sqlx.DB
+	pubKey   ed25519.PublicKey
 }
 
 // register dbx driver
@@ -42,9 +60,21 @@ func init() {
 // - driver			: string*
 // - selectQuery	: string*
 // - storeQuery		: string*
-// - dsn			: string*
-// - cronSpec		: string*
+// - dsn			: string* (may be secret reference, e.g. secret://file/run/secrets/dsn)
+// - cronSpec		: string (schedule of change check, changes are not monitored if empty)
+// - publicKey		: string (base64 ed25519 key, when set loadQuery must
+//					  select configuration and its signature columns, @file links are rejected)
+// - versioned		: bool (when set loadQuery must select version as last column,
+//					  storeQuery receives configuration and loaded version and
+//					  must update the row only if the version matches, e.g.
+//					  UPDATE conf SET content=$1, version=version+1 WHERE version=$2)
 func (dd *dbDriver) Connect(h func(f int) error, prop *opt.Options) (opt.Connector, error) {
+	return dd.ConnectContext(context.Background(), h, prop)
+}
+
+// ConnectContext connects to the configuration source, see Connect for options.
+// The context is used for pinging the database only.
+func (dd *dbDriver) ConnectContext(ctx context.Context, h func(f int) error, prop *opt.Options) (opt.Connector, error) {
 	op := driverOptions{
 		Driver: "sqlite3",
 	}
@@ -57,81 +87,182 @@ func (dd *dbDriver) Connect(h func(f int) error, prop *opt.Options) (opt.Connect
 		c:       cron.New(),
 		dbx:     nil,
 	}
-	if err := dc.connect(); err != nil {
+	if op.PublicKey != "" {
+		key, err := opt.ParsePublicKey(op.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		dc.pubKey = key
+	}
+	if err := dc.connect(ctx); err != nil {
 		return nil, err
 	}
 	return dc, nil
 }
 
 // connect to database
-func (dc *dbConnector) connect() error {
-	dbx, err := sqlx.Open(dc.op.Driver, dc.op.DSN)
+func (dc *dbConnector) connect(ctx context.Context) error {
+	dsn, err := opt.ResolveSecret(dc.op.DSN)
+	if err != nil {
+		return err
+	}
+	dbx, err := sqlx.Open(dc.op.Driver, dsn)
 	if err != nil {
 		return err
 	}
-	if err := dbx.Ping(); err != nil {
+	if err := dbx.PingContext(ctx); err != nil {
+		dbx.Close()
 		return err
 	}
 	dc.dbx = dbx
 
-	// monitor database change using cron
-	if dc.handler != nil {
-		dc.c.AddFunc(dc.op.CronSpec, func() {
+	// monitor database change using cron, if cronSpec is set
+	if dc.handler != nil && dc.op.CronSpec != "" {
+		_, err := dc.c.AddFunc(dc.op.CronSpec, func() {
 			// get last loaded config
 			dc.mu.Lock()
-			lastConfig := dc.lastConf
+			lastHash := dc.lastHash
 			dc.mu.Unlock()
 
 			// read config in DB
-			config := ""
-			if err := dbx.Get(&config, dc.op.LoadQuery); err == nil {
-				if lastConfig != "" && config != lastConfig {
-					if err := dc.handler(opt.SourceModified); err != nil {
-						log.Printf("[OPT] dbDriver cron, handler error: %v", err)
-					}
+			row, err := dc.query(context.Background(), dc.dbx)
+			if err != nil {
+				dc.Report(&opt.DriverError{Driver: "database", Op: "get config", Err: err})
+				return
+			}
+			op, err := opt.FromText(row.config, opt.FormatJSON)
+			if err != nil {
+				dc.Report(&opt.DriverError{Driver: "database", Op: "parse config", Err: err})
+				return
+			}
+			dc.ReportSuccess()
+			if lastHash != "" && op.Hash() != lastHash {
+				if err := dc.handler(opt.SourceModified); err != nil {
+					dc.Report(&opt.DriverError{Driver: "database", Op: "reload", Err: err})
 				}
-			} else {
-				log.Printf("[OPT] dbDriver cron, get config error: %v", err)
 			}
 		})
+		if err != nil {
+			return err
+		}
+		dc.c.Start()
 	}
 
 	return nil
 }
 
+// query selects configuration row, columns: config[, signature][, version]
+func (dc *dbConnector) query(ctx context.Context, q rowQueryer) (configRow, error) {
+	row := configRow{}
+	if dc.dbx == nil {
+		return row, errors.New("database not connected")
+	}
+	dest := []interface{}{&row.config}
+	if dc.pubKey != nil {
+		dest = append(dest, &row.signature)
+	}
+	if dc.op.Versioned {
+		dest = append(dest, &row.version)
+	}
+	err := q.QueryRowContext(ctx, dc.op.LoadQuery).Scan(dest...)
+	return row, err
+}
+
 // Load read configuration from dbx. The database must be in JSON format
 func (dc *dbConnector) Load() (*opt.Options, error) {
+	return dc.LoadContext(context.Background())
+}
+
+// LoadContext read configuration from dbx, query is bound to the context
+func (dc *dbConnector) LoadContext(ctx context.Context) (*opt.Options, error) {
+	op, _, err := dc.LoadVersion(ctx)
+	return op, err
+}
+
+// LoadVersion read configuration from dbx, the revision is the version
+// column if versioned option is set
+func (dc *dbConnector) LoadVersion(ctx context.Context) (*opt.Options, string, error) {
 	// load configuration from dbx
-	if dc.dbx == nil {
-		return nil, errors.New("database not connected")
-	}
-	config := ""
-	if err := dc.dbx.Get(&config, dc.op.LoadQuery); err != nil {
-		return nil, err
+	row, err := dc.query(ctx, dc.dbx)
+	if err != nil {
+		return nil, "", err
 	}
-	op, err := opt.FromReader(strings.NewReader(config), opt.FormatJSON)
+	op, err := opt.FromReader(strings.NewReader(row.config), opt.FormatJSON)
 	if err != nil {
-		return nil, err
+		return nil, "", err
+	}
+	if dc.pubKey != nil {
+		if err := opt.Verify(op, dc.pubKey, row.signature); err != nil {
+			return nil, "", err
+		}
 	}
 	dc.mu.Lock()
-	dc.lastConf = config
+	dc.lastHash = op.Hash()
 	dc.mu.Unlock()
 
-	return op, nil
+	return op, row.version, nil
 }
 
 // Store save configuration to dbx
 func (dc *dbConnector) Store(v *opt.Options) error {
+	return dc.StoreContext(context.Background(), v)
+}
+
+// StoreContext save configuration to dbx, query is bound to the context
+func (dc *dbConnector) StoreContext(ctx context.Context, v *opt.Options) error {
+	_, err := dc.StoreVersion(ctx, v, "")
+	return err
+}
+
+// StoreVersion save configuration to dbx. If versioned option is set,
+// ErrConflict is returned when no row is updated by storeQuery.
+// Empty revision overwrites the current version.
+func (dc *dbConnector) StoreVersion(ctx context.Context, v *opt.Options, revision string) (string, error) {
 	if v == nil {
-		return errors.New("dbConnector: config parameter is nil")
+		return "", errors.New("dbConnector: config parameter is nil")
 	}
 	if dc.dbx == nil {
-		return errors.New("database not connected")
+		return "", errors.New("database not connected")
 	}
 
 	// execute query
-	_, err := dc.dbx.Exec(dc.op.StoreQuery, v.AsJSON())
-	return err
+	if !dc.op.Versioned {
+		_, err := dc.dbx.ExecContext(ctx, dc.op.StoreQuery, v.AsJSON())
+		return "", err
+	}
+
+	tx, err := dc.dbx.BeginTxx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	if revision == "" {
+		row, err := dc.query(ctx, tx)
+		if err != nil {
+			return "", err
+		}
+		revision = row.version
+	}
+	res, err := tx.ExecContext(ctx, dc.op.StoreQuery, v.AsJSON(), revision)
+	if err != nil {
+		return "", err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return "", err
+	} else if n == 0 {
+		return "", opt.ErrConflict
+	}
+
+	// read new version within the transaction
+	row, err := dc.query(ctx, tx)
+	if err != nil {
+		return "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+	return row.version, nil
 }
 
 // Close dbx connection
@@ -0,0 +1,273 @@
+package db
+
+import (
+	"context"
+	"crypto/ed25519"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/base64"
+	"errors"
+	"io"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/ipsusila/opt"
+)
+
+// fakeDB is in-memory configuration table used through database/sql.
+// Query selects config[, signature][, version], exec stores config and
+// with version argument updates the row only if the version matches.
+type fakeDB struct {
+	mu            sync.Mutex
+	config        string
+	signature     string
+	version       int64
+	withSignature bool
+	withVersion   bool
+}
+
+var (
+	fakeMu  sync.Mutex
+	fakeDBs = map[string]*fakeDB{}
+)
+
+func init() {
+	sql.Register("opttest", fakeDriver{})
+}
+
+// newFakeDB registers fake database, returns it with its DSN
+func newFakeDB(t *testing.T, config string) (*fakeDB, string) {
+	fakeMu.Lock()
+	defer fakeMu.Unlock()
+
+	db := &fakeDB{config: config, version: 1}
+	fakeDBs[t.Name()] = db
+	return db, t.Name()
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(dsn string) (driver.Conn, error) {
+	fakeMu.Lock()
+	defer fakeMu.Unlock()
+
+	db, ok := fakeDBs[dsn]
+	if !ok {
+		return nil, errors.New("unknown database " + dsn)
+	}
+	return &fakeConn{db: db}, nil
+}
+
+type fakeConn struct {
+	db *fakeDB
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{db: c.db}, nil
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return c, nil
+}
+
+func (c *fakeConn) Commit() error {
+	return nil
+}
+
+func (c *fakeConn) Rollback() error {
+	return nil
+}
+
+type fakeStmt struct {
+	db *fakeDB
+}
+
+func (s *fakeStmt) Close() error {
+	return nil
+}
+
+func (s *fakeStmt) NumInput() int {
+	return -1
+}
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	db := s.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if len(args) > 1 {
+		if version, ok := args[1].(string); !ok || version != strconv.FormatInt(db.version, 10) {
+			return driver.RowsAffected(0), nil
+		}
+	}
+	db.config = args[0].(string)
+	db.version++
+	return driver.RowsAffected(1), nil
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	db := s.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	row := []driver.Value{db.config}
+	cols := []string{"config"}
+	if db.withSignature {
+		row = append(row, db.signature)
+		cols = append(cols, "signature")
+	}
+	if db.withVersion {
+		row = append(row, db.version)
+		cols = append(cols, "version")
+	}
+	return &fakeRows{cols: cols, row: row}, nil
+}
+
+type fakeRows struct {
+	cols []string
+	row  []driver.Value
+	done bool
+}
+
+func (r *fakeRows) Columns() []string {
+	return r.cols
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	copy(dest, r.row)
+	return nil
+}
+
+func connectFake(t *testing.T, dsn string, h func(f int) error, set map[string]interface{}) opt.Connector {
+	t.Helper()
+	prop := opt.New()
+	prop.Set("driver", "opttest")
+	prop.Set("dsn", dsn)
+	prop.Set("loadQuery", "SELECT config FROM conf")
+	prop.Set("storeQuery", "UPDATE conf SET config=?")
+	for key, val := range set {
+		prop.Set(key, val)
+	}
+	conn, err := (&dbDriver{}).ConnectContext(context.Background(), h, prop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+func TestConnectWithoutCron(t *testing.T) {
+	_, dsn := newFakeDB(t, `{"a": 1}`)
+
+	// change monitoring is disabled without cronSpec
+	conn := connectFake(t, dsn, func(f int) error { return nil }, nil)
+	defer conn.Close()
+	op, err := conn.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if op.GetInt("a", 0) != 1 {
+		t.Fatalf("Unexpected configuration %s", op.AsJSON())
+	}
+}
+
+func TestSignature(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, dsn := newFakeDB(t, "")
+	db.withSignature = true
+	sign := func(content string) {
+		op, err := opt.FromText(content, opt.FormatJSON)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg, err := op.Canonical()
+		if err != nil {
+			t.Fatal(err)
+		}
+		db.mu.Lock()
+		db.config = content
+		db.signature = base64.StdEncoding.EncodeToString(ed25519.Sign(priv, msg))
+		db.mu.Unlock()
+	}
+	sign(`{"a": 1}`)
+
+	conn := connectFake(t, dsn, nil, map[string]interface{}{
+		"loadQuery": "SELECT config, signature FROM conf",
+		"publicKey": base64.StdEncoding.EncodeToString(pub),
+	})
+	defer conn.Close()
+	if _, err := conn.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	// tampered row
+	db.mu.Lock()
+	db.config = `{"a": 2}`
+	db.mu.Unlock()
+	if _, err := conn.Load(); err != opt.ErrInvalidSignature {
+		t.Fatalf("Expecting ErrInvalidSignature, got %v", err)
+	}
+
+	// linked file is not covered by the signature
+	sign(`{"db": "@db.json"}`)
+	if _, err := conn.Load(); err != opt.ErrLinkedFile {
+		t.Fatalf("Expecting ErrLinkedFile, got %v", err)
+	}
+}
+
+func TestStoreVersion(t *testing.T) {
+	db, dsn := newFakeDB(t, `{"a": 1}`)
+	db.withVersion = true
+	c := connectFake(t, dsn, nil, map[string]interface{}{
+		"loadQuery":  "SELECT config, version FROM conf",
+		"storeQuery": "UPDATE conf SET config=?, version=version+1 WHERE version=?",
+		"versioned":  true,
+	})
+	defer c.Close()
+	conn := c.(opt.VersionedConnector)
+
+	op, rev, err := conn.LoadVersion(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rev != "1" {
+		t.Fatalf("Revision must be the version column, got %q", rev)
+	}
+	op = op.Clone()
+	op.Set("a", 2)
+	rev, err = conn.StoreVersion(context.Background(), op, rev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rev != "2" {
+		t.Fatalf("Expecting new revision 2, got %q", rev)
+	}
+
+	// no row updated, changed by other client
+	db.mu.Lock()
+	db.version++
+	db.mu.Unlock()
+	if _, err := conn.StoreVersion(context.Background(), op, rev); err != opt.ErrConflict {
+		t.Fatalf("Expecting ErrConflict, got %v", err)
+	}
+
+	// empty revision overwrites the current version
+	if _, err := conn.StoreVersion(context.Background(), op, ""); err != nil {
+		t.Fatal(err)
+	}
+}
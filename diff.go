@@ -0,0 +1,94 @@
+package opt
+
+import (
+	"sort"
+)
+
+// ChangeKind marks type of key change
+type ChangeKind int
+
+// Known change kinds
+const (
+	KeyAdded ChangeKind = iota
+	KeyRemoved
+	KeyModified
+)
+
+// String returns change kind name
+func (k ChangeKind) String() string {
+	switch k {
+	case KeyAdded:
+		return "added"
+	case KeyRemoved:
+		return "removed"
+	case KeyModified:
+		return "modified"
+	}
+	return "unknown"
+}
+
+// Change describes added/removed/modified key. Key is the full path
+// separated by dot, e.g. server.port
+type Change struct {
+	Key      string
+	Kind     ChangeKind
+	OldValue interface{}
+	NewValue interface{}
+}
+
+// ConfigurableDiff is Configurable which is informed about detailed change
+// on reload. When registered, Configure is called on first configuration,
+// then ConfigureDiff is called with old and new section and the list of
+// changed keys (relative to the section).
+type ConfigurableDiff interface {
+	Configurable
+	ConfigureDiff(oldOp, newOp *Options, changes []Change)
+}
+
+// Diff returns list of changed keys between old and new options, sorted by key.
+// Objects are compared recursively, arrays are compared as a single value.
+func Diff(oldOp, newOp *Options) []Change {
+	oldMap := map[string]interface{}{}
+	newMap := map[string]interface{}{}
+	if oldOp != nil {
+		if oldOp.rlock() {
+			defer oldOp.RUnlock()
+		}
+		oldMap = oldOp.options
+	}
+	if newOp != nil {
+		if newOp.rlock() {
+			defer newOp.RUnlock()
+		}
+		newMap = newOp.options
+	}
+
+	changes := []Change{}
+	diffMap("", oldMap, newMap, &changes)
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Key < changes[j].Key
+	})
+	return changes
+}
+
+func diffMap(prefix string, oldMap, newMap map[string]interface{}, changes *[]Change) {
+	for key, oldVal := range oldMap {
+		newVal, ok := newMap[key]
+		if !ok {
+			*changes = append(*changes, Change{Key: prefix + key, Kind: KeyRemoved, OldValue: copyValue(oldVal)})
+			continue
+		}
+		oldSub, oldIsMap := oldVal.(map[string]interface{})
+		newSub, newIsMap := newVal.(map[string]interface{})
+		if oldIsMap && newIsMap {
+			diffMap(prefix+key+".", oldSub, newSub, changes)
+		} else if !DeepEqual(oldVal, newVal) {
+			*changes = append(*changes, Change{Key: prefix + key, Kind: KeyModified, OldValue: copyValue(oldVal), NewValue: copyValue(newVal)})
+		}
+	}
+	for key, newVal := range newMap {
+		if _, ok := oldMap[key]; !ok {
+			*changes = append(*changes, Change{Key: prefix + key, Kind: KeyAdded, NewValue: copyValue(newVal)})
+		}
+	}
+}
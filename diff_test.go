@@ -0,0 +1,62 @@
+package opt
+
+import (
+	"testing"
+)
+
+type testConfigurableDiff struct {
+	testConfigurable
+	changes []Change
+}
+
+func (tc *testConfigurableDiff) ConfigureDiff(oldOp, newOp *Options, changes []Change) {
+	tc.Configure(newOp, false)
+	tc.changes = changes
+}
+
+func TestDiff(t *testing.T) {
+	oldOp, _ := FromText(`{"port": 8080, "host": "localhost", "tls": {"cert": "a.pem", "key": "a.key"}}`, FormatJSON)
+	newOp, _ := FromText(`{"port": 8081, "tls": {"cert": "b.pem", "key": "a.key"}, "debug": true}`, FormatJSON)
+
+	changes := Diff(oldOp, newOp)
+	expect := []struct {
+		key  string
+		kind ChangeKind
+	}{
+		{"debug", KeyAdded},
+		{"host", KeyRemoved},
+		{"port", KeyModified},
+		{"tls.cert", KeyModified},
+	}
+	if len(changes) != len(expect) {
+		t.Fatalf("Expecting %d changes, got %+v", len(expect), changes)
+	}
+	for i, c := range changes {
+		if c.Key != expect[i].key || c.Kind != expect[i].kind {
+			t.Fatalf("Expecting %s %v, got %s %v", expect[i].key, expect[i].kind, c.Key, c.Kind)
+		}
+		t.Logf("%s %v: %v -> %v", c.Key, c.Kind, c.OldValue, c.NewValue)
+	}
+
+	if changes := Diff(oldOp, oldOp.Clone()); len(changes) != 0 {
+		t.Fatalf("Expecting no changes, got %+v", changes)
+	}
+}
+
+func TestConfigurableDiff(t *testing.T) {
+	cfg, conn := newTestConfigurator(t, `{"server": {"port": 8080, "host": "localhost"}}`)
+	defer cfg.Close()
+
+	server := &testConfigurableDiff{}
+	cfg.Register("server", server)
+
+	if err := conn.change(`{"server": {"port": 9090, "host": "localhost"}}`); err != nil {
+		t.Fatal(err)
+	}
+	if len(server.changes) != 1 || server.changes[0].Key != "port" {
+		t.Fatalf("Expecting port changed, got %+v", server.changes)
+	}
+	if server.count != 2 || server.last.GetInt("port", 0) != 9090 {
+		t.Fatalf("New section not delivered")
+	}
+}
@@ -1,6 +1,8 @@
 package opt
 
 import (
+	"context"
+	"errors"
 	"sort"
 	"sync"
 )
@@ -31,6 +33,67 @@ type Connector interface {
 	Close() error
 }
 
+// ContextDriver is Driver which connection can be cancelled
+type ContextDriver interface {
+	Driver
+	ConnectContext(ctx context.Context, h func(f int) error, prop *Options) (Connector, error)
+}
+
+// ContextConnector is Connector which Load and Store can be cancelled
+type ContextConnector interface {
+	Connector
+	LoadContext(ctx context.Context) (*Options, error)
+	StoreContext(ctx context.Context, v *Options) error
+}
+
+// ErrConflict is returned by StoreVersion when the source is changed
+// since the configuration was loaded
+var ErrConflict = errors.New("configuration source changed since loaded")
+
+// VersionedConnector is Connector which supports optimistic concurrency.
+// LoadVersion returns the configuration with its source revision,
+// StoreVersion saves the configuration only if the source revision
+// still equals to the given one, otherwise ErrConflict is returned.
+// Empty revision disables the check. StoreVersion returns the new revision.
+type VersionedConnector interface {
+	Connector
+	LoadVersion(ctx context.Context) (*Options, string, error)
+	StoreVersion(ctx context.Context, v *Options, revision string) (string, error)
+}
+
+// connectContext connects using ConnectContext if supported by the driver
+func connectContext(ctx context.Context, drv Driver, h func(f int) error, prop *Options) (Connector, error) {
+	if cd, ok := drv.(ContextDriver); ok {
+		return cd.ConnectContext(ctx, h, prop)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return drv.Connect(h, prop)
+}
+
+// loadContext loads using LoadContext if supported by the connector
+func loadContext(ctx context.Context, conn Connector) (*Options, error) {
+	if cc, ok := conn.(ContextConnector); ok {
+		return cc.LoadContext(ctx)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return conn.Load()
+}
+
+// storeContext stores using StoreContext if supported by the connector
+func storeContext(ctx context.Context, conn Connector, v *Options) error {
+	if cc, ok := conn.(ContextConnector); ok {
+		return cc.StoreContext(ctx, v)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return conn.Store(v)
+}
+
 // Register makes a driver available by the provided name.
 // If Register is called twice with the same name or if driver is nil,
 // it panics.
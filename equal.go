@@ -0,0 +1,222 @@
+package opt
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"math"
+	"reflect"
+	"sort"
+)
+
+// DeepEqual compares two configuration values structurally. Numbers are
+// normalized to float64, so 1 (int) equals to 1.0 (float64). Typed slices,
+// maps and structs are compared by their JSON representation.
+func DeepEqual(a, b interface{}) bool {
+	// typed slice, map or struct, e.g. set by Options.Set
+	if na, ok := normalize(a); ok {
+		a = na
+	}
+	if nb, ok := normalize(b); ok {
+		b = nb
+	}
+
+	if fa, ok := toFloat(a); ok {
+		fb, ok := toFloat(b)
+		return ok && fa == fb
+	}
+
+	switch va := a.(type) {
+	case map[string]interface{}:
+		vb, ok := b.(map[string]interface{})
+		if !ok || len(va) != len(vb) {
+			return false
+		}
+		for key, item := range va {
+			other, ok := vb[key]
+			if !ok || !DeepEqual(item, other) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		vb, ok := b.([]interface{})
+		if !ok || len(va) != len(vb) {
+			return false
+		}
+		for i := range va {
+			if !DeepEqual(va[i], vb[i]) {
+				return false
+			}
+		}
+		return true
+	case string:
+		vb, ok := b.(string)
+		return ok && va == vb
+	case bool:
+		vb, ok := b.(bool)
+		return ok && va == vb
+	case nil:
+		return b == nil
+	}
+
+	return fmt.Sprintf("%#v", a) == fmt.Sprintf("%#v", b)
+}
+
+// normalize converts value of other type than parsed from JSON to the
+// JSON representation: typed slices to []interface{}, maps with string keys
+// to map[string]interface{} and other values by JSON round-trip.
+// Returns false if the value can not be converted.
+func normalize(val interface{}) (interface{}, bool) {
+	switch val.(type) {
+	case map[string]interface{}, []interface{}, string, bool, nil:
+		return nil, false
+	}
+	if _, ok := toFloat(val); ok {
+		return nil, false
+	}
+
+	rv := reflect.ValueOf(val)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		if rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() == reflect.Uint8 {
+			break
+		}
+		if rv.Kind() == reflect.Slice && rv.IsNil() {
+			return nil, true
+		}
+		items := make([]interface{}, rv.Len())
+		for i := range items {
+			items[i] = rv.Index(i).Interface()
+		}
+		return items, true
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			break
+		}
+		if rv.IsNil() {
+			return nil, true
+		}
+		m := make(map[string]interface{}, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			m[iter.Key().String()] = iter.Value().Interface()
+		}
+		return m, true
+	}
+
+	content, err := json.Marshal(val)
+	if err != nil {
+		return nil, false
+	}
+	var v interface{}
+	if err := json.Unmarshal(content, &v); err != nil {
+		return nil, false
+	}
+	return v, true
+}
+
+// Hash returns hex encoded SHA-256 of the options canonical form.
+// Structurally equal options (see DeepEqual) produce the same hash.
+func (o *Options) Hash() string {
+	if o.rlock() {
+		defer o.RUnlock()
+	}
+
+	h := sha256.New()
+	hashValue(h, o.options)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// hashValue writes type tagged value to h, map keys are sorted
+func hashValue(h hash.Hash, val interface{}) {
+	buf := make([]byte, 8)
+	writeLen := func(tag byte, n int) {
+		binary.BigEndian.PutUint64(buf, uint64(n))
+		h.Write([]byte{tag})
+		h.Write(buf)
+	}
+
+	if f, ok := toFloat(val); ok {
+		if f == 0 {
+			f = 0 // normalize -0
+		}
+		binary.BigEndian.PutUint64(buf, math.Float64bits(f))
+		h.Write([]byte{'f'})
+		h.Write(buf)
+		return
+	}
+
+	switch v := val.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		writeLen('m', len(keys))
+		for _, key := range keys {
+			writeLen('s', len(key))
+			h.Write([]byte(key))
+			hashValue(h, v[key])
+		}
+	case []interface{}:
+		writeLen('a', len(v))
+		for _, item := range v {
+			hashValue(h, item)
+		}
+	case string:
+		writeLen('s', len(v))
+		h.Write([]byte(v))
+	case bool:
+		if v {
+			h.Write([]byte{'t'})
+		} else {
+			h.Write([]byte{'F'})
+		}
+	case nil:
+		h.Write([]byte{'n'})
+	default:
+		if nv, ok := normalize(v); ok {
+			hashValue(h, nv)
+			return
+		}
+		s := fmt.Sprintf("%#v", v)
+		writeLen('x', len(s))
+		h.Write([]byte(s))
+	}
+}
+
+// toFloat converts numeric value to float64
+func toFloat(val interface{}) (float64, bool) {
+	switch v := val.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case int16:
+		return float64(v), true
+	case uint16:
+		return float64(v), true
+	case int8:
+		return float64(v), true
+	case uint8:
+		return float64(v), true
+	}
+	return 0, false
+}
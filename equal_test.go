@@ -0,0 +1,74 @@
+package opt
+
+import (
+	"testing"
+)
+
+func TestDeepEqual(t *testing.T) {
+	op1, err := FromText(`{"port": 8080, "ratio": 1.0, "tags": ["a", "b"], "db": {"user": "postgres"}}`, FormatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	op2 := New()
+	op2.Set("db.user", "postgres")
+	op2.Set("port", 8080)
+	op2.Set("ratio", int64(1))
+	op2.Set("tags", []interface{}{"a", "b"})
+
+	if !op1.EqualTo(op2) || !op2.EqualTo(op1) {
+		t.Fatalf("Options must be equal:\n%s\n%s", op1.AsJSON(), op2.AsJSON())
+	}
+	if op1.Hash() != op2.Hash() {
+		t.Fatalf("Hash must be equal: %s <> %s", op1.Hash(), op2.Hash())
+	}
+	t.Logf("Hash: %s", op1.Hash())
+
+	op2.Set("tags", []interface{}{"b", "a"})
+	if op1.EqualTo(op2) || op1.Hash() == op2.Hash() {
+		t.Fatalf("Array order must be significant")
+	}
+	op2.Set("tags", []interface{}{"a", "b"})
+	op2.Set("port", "8080")
+	if op1.EqualTo(op2) || op1.Hash() == op2.Hash() {
+		t.Fatalf("String and number must not be equal")
+	}
+
+	// typed values equal to the same data loaded from JSON
+	type level string
+	op2.Set("port", 8080)
+	op2.Set("tags", []string{"a", "b"})
+	op2.Set("db", map[string]string{"user": "postgres"})
+	if !op1.EqualTo(op2) || op1.Hash() != op2.Hash() {
+		t.Fatalf("Typed slice and map must equal to JSON values:\n%s\n%s", op1.AsJSON(), op2.AsJSON())
+	}
+	if changes := Diff(op1, op2); len(changes) != 0 {
+		t.Fatalf("Expecting no changes, got %v", changes)
+	}
+	if !DeepEqual([]level{"a"}, []interface{}{"a"}) || !DeepEqual(struct{ A int }{1}, map[string]interface{}{"A": 1}) {
+		t.Fatalf("Named types and struct must be compared by JSON representation")
+	}
+	op2.Set("tags", []string{"a", "c"})
+	if op1.EqualTo(op2) || op1.Hash() == op2.Hash() {
+		t.Fatalf("Different typed slice must not be equal")
+	}
+
+	if DeepEqual(map[string]interface{}{"a": nil}, map[string]interface{}{"b": nil}) {
+		t.Fatalf("Different keys must not be equal")
+	}
+	if op1.EqualTo(nil) {
+		t.Fatalf("Options must not equal to nil")
+	}
+}
+
+func BenchmarkEqualTo(b *testing.B) {
+	op := New()
+	for i := 0; i < 100; i++ {
+		op.Set(string(rune('a'+i%26))+".key"+string(rune('0'+i%10)), i)
+	}
+	other := op.Clone()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		op.EqualTo(other)
+	}
+}
@@ -0,0 +1,87 @@
+package file
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ipsusila/opt"
+)
+
+// source returns configuration file, archive containing the file or directory
+func (fc *fileConnector) source() string {
+	if fc.op.Directory != "" {
+		return fc.op.Directory
+	}
+	archive, _ := opt.SplitArchivePath(fc.op.FileName)
+	return archive
+}
+
+// files returns configuration files, in directory mode all fragments
+// matching pattern sorted by name
+func (fc *fileConnector) files() ([]string, error) {
+	if fc.op.Directory == "" {
+		return []string{filepath.Clean(fc.source())}, nil
+	}
+
+	matches, err := filepath.Glob(filepath.Join(filepath.Clean(fc.op.Directory), fc.op.Pattern))
+	if err != nil {
+		return nil, err
+	}
+	files := matches[:0]
+	for _, name := range matches {
+		if !fc.isFragment(name) {
+			continue
+		}
+		// skip directories, symlink is followed
+		if fi, err := os.Stat(name); err != nil || fi.IsDir() {
+			continue
+		}
+		files = append(files, name)
+	}
+	sort.Strings(files)
+
+	return files, nil
+}
+
+// isFragment returns true if name is configuration fragment in directory mode.
+// Hidden files (editor swap files, ConfigMap ..data) are ignored and with
+// auto format only json and hjson files are loaded.
+func (fc *fileConnector) isFragment(name string) bool {
+	base := filepath.Base(name)
+	if strings.HasPrefix(base, ".") {
+		return false
+	}
+	if ok, _ := filepath.Match(fc.op.Pattern, base); !ok {
+		return false
+	}
+	if fc.op.Format == opt.FormatAuto {
+		base = strings.TrimSuffix(strings.ToLower(base), ".gz")
+		ext := strings.Trim(filepath.Ext(base), ".")
+		return ext == opt.FormatJSON || ext == opt.FormatHJSON
+	}
+	return true
+}
+
+// load reads configuration file, in directory mode fragments are merged
+// in order, latter fragment overrides values of former
+func (fc *fileConnector) load() (*opt.Options, error) {
+	if fc.op.Directory == "" {
+		return opt.FromFile(fc.op.FileName, fc.op.Format)
+	}
+
+	files, err := fc.files()
+	if err != nil {
+		return nil, err
+	}
+	op := opt.New()
+	for _, name := range files {
+		fop, err := opt.FromFile(name, fc.op.Format)
+		if err != nil {
+			return nil, err
+		}
+		op.Merge(fop)
+	}
+	return op, nil
+}
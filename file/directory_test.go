@@ -0,0 +1,78 @@
+package file
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ipsusila/opt"
+)
+
+func TestDirectory(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	writeFile(t, filepath.Join(dir, "20-override.hjson"), "{\n  log: {\n    level: debug\n  }\n}")
+	writeFile(t, filepath.Join(dir, "10-base.json"), `{"a": 1, "log": {"level": "info", "file": "app.log"}}`)
+	writeFile(t, filepath.Join(dir, "README.md"), `{"a": 2}`)
+	writeFile(t, filepath.Join(dir, ".30-swap.json"), `{"a": 3}`)
+
+	prop := opt.New()
+	prop.Set("directory", dir)
+	prop.Set("watch", watchFsnotify)
+	conn, events := connectTest(t, prop)
+	defer conn.Close()
+
+	// fragments are merged in name order, other files are skipped
+	op, err := conn.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if op.GetInt("a", 0) != 1 || op.GetString("log.level", "") != "debug" || op.GetString("log.file", "") != "app.log" {
+		t.Fatalf("Unexpected merged configuration %s", op.AsJSON())
+	}
+
+	// hidden and non configuration files are not watched
+	writeFile(t, filepath.Join(dir, ".40-swap.json"), `{"a": 4}`)
+	writeFile(t, filepath.Join(dir, "notes.txt"), `{"a": 4}`)
+	expectNoEvent(t, events)
+
+	writeFile(t, filepath.Join(dir, "30-extra.json"), `{"a": 3}`)
+	expectEvent(t, events, opt.SourceModified)
+	drainEvents(events)
+	expectValue(t, conn, "a", 3)
+
+	if err := os.Remove(filepath.Join(dir, "30-extra.json")); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(t, events, opt.SourceModified)
+	expectValue(t, conn, "a", 1)
+
+	if err := conn.Store(op); err == nil {
+		t.Fatalf("Store must fail in directory mode")
+	}
+}
+
+func TestDirectoryPattern(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	writeFile(t, filepath.Join(dir, "app.json"), `{"a": 1}`)
+	writeFile(t, filepath.Join(dir, "app.prod.json"), `{"a": 2}`)
+	writeFile(t, filepath.Join(dir, "db.json"), `{"a": 3, "db": true}`)
+
+	prop := opt.New()
+	prop.Set("directory", dir)
+	prop.Set("pattern", "app*.json")
+	conn, err := (&fileDriver{}).Connect(nil, prop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	op, err := conn.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if op.GetInt("a", 0) != 2 || op.Exists("db") {
+		t.Fatalf("Only fragments matching pattern must be loaded, got %s", op.AsJSON())
+	}
+}
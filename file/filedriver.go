@@ -1,8 +1,17 @@
 package file
 
 import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
@@ -12,8 +21,15 @@ import (
 type driverOptions struct {
 	Format         string       `json:"format"`
 	FileName       string       `json:"fileName"`
+	Directory      string       `json:"directory"`
+	Pattern        string       `json:"pattern"`
+	Backups        int          `json:"backups"`
 	EventDelay     opt.Duration `json:"eventDelay"`
 	EventQueueSize int          `json:"eventQueueSize"`
+	Watch          string       `json:"watch"`
+	PollInterval   opt.Duration `json:"pollInterval"`
+	PublicKey      string       `json:"publicKey"`
+	SignatureFile  string       `json:"signatureFile"`
 }
 
 //file driver configuration
@@ -22,11 +38,21 @@ type fileDriver struct {
 
 //file connector
 type fileConnector struct {
+	opt.Reporter
+	mu      sync.Mutex
 	handler func(f int) error
 	op      driverOptions
 	quit    chan bool
 	done    chan bool
 	watcher *fsnotify.Watcher
+	pubKey  ed25519.PublicKey
+
+	// hash of content written by Store, its watch event is suppressed
+	selfHash string
+
+	// owned by watching loop, file name to its symlink target
+	links map[string]string
+	dirs  map[string]bool
 }
 
 // register file driver
@@ -36,18 +62,41 @@ func init() {
 
 // Connect to the configuration source. Connection options:
 // - format			: string
-// - fileName		: string*
+// - fileName		: string* (or directory), may be gzip file or file inside archive, e.g. bundle.zip#app/config.hjson
+// - directory		: string* (load and merge all files matching pattern, sorted by name)
+// - pattern		: string (glob of files in directory, default *)
+// - backups		: int (number of backups kept on store, fileName.1 is the newest)
 // - eventDelay		: duration
-// - eventQueueSize	: int
+// - eventQueueSize	: int (deprecated, events are coalesced)
+// - watch			: string (fsnotify, poll or auto, default auto: fsnotify with polling fallback)
+// - pollInterval	: duration (default 5s, must be positive)
+// - publicKey		: string (base64 ed25519 key, when set signature is verified on load, @file links are rejected)
+// - signatureFile	: string (detached signature, default fileName + ".sig" or directory + ".sig")
+//
+// In auto mode polling is used only if fsnotify watcher can not be created
+// or the directory can not be watched. File systems which accept the watch
+// but never deliver events (e.g. NFS, SMB, some container volumes) are not
+// detected, use poll mode for them.
 func (fd *fileDriver) Connect(h func(f int) error, prop *opt.Options) (opt.Connector, error) {
 	op := driverOptions{
 		Format:         opt.FormatAuto,
 		EventDelay:     opt.Duration{Duration: 2 * time.Second},
 		EventQueueSize: 10,
+		Watch:          watchAuto,
+		PollInterval:   opt.Duration{Duration: 5 * time.Second},
 	}
 	if err := prop.AsStruct(&op); err != nil {
 		return nil, err
 	}
+	if op.Watch != watchAuto && op.Watch != watchFsnotify && op.Watch != watchPoll {
+		return nil, errors.New("unsupported watch mode " + op.Watch)
+	}
+	if op.PollInterval.Duration <= 0 {
+		return nil, errors.New("pollInterval must be positive")
+	}
+	if op.Pattern == "" {
+		op.Pattern = "*"
+	}
 
 	// create file connector
 	fc := &fileConnector{
@@ -57,6 +106,16 @@ func (fd *fileDriver) Connect(h func(f int) error, prop *opt.Options) (opt.Conne
 		done:    make(chan bool, 1),
 	}
 	close(fc.done)
+	if op.PublicKey != "" {
+		key, err := opt.ParsePublicKey(op.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		fc.pubKey = key
+		if fc.op.SignatureFile == "" {
+			fc.op.SignatureFile = fc.source() + ".sig"
+		}
+	}
 	if err := fc.openFile(); err != nil {
 		return nil, err
 	}
@@ -67,55 +126,114 @@ func (fd *fileDriver) Connect(h func(f int) error, prop *opt.Options) (opt.Conne
 func (fc *fileConnector) watchFileChanged() {
 	defer close(fc.done)
 
-	// create ticker, every 2 seconds
+	// events are coalesced and handler is informed every eventDelay
 	ticker := time.NewTicker(fc.op.EventDelay.Duration)
 	defer ticker.Stop()
 
-	// Fetch latest event only
-	changes := make(chan fsnotify.Event, fc.op.EventQueueSize)
-	fnLastEvent := func() (fsnotify.Event, bool) {
-		found := false
-		ev := fsnotify.Event{}
-		for {
-			select {
-			case e, ok := <-changes:
-				if ok {
-					ev = e
-					found = true
-				}
-			default:
-				return ev, found
-			}
-		}
-		// need this?
-		// return ev, found
-	}
-
+	changed := false
 	for {
 		select {
 		case <-fc.quit:
 			return
 		case <-ticker.C:
-			if ev, found := fnLastEvent(); found {
-				// inform handler that configuration source changed
-				if err := fc.handler(int(ev.Op)); err != nil {
-					//TODO: display error
-				}
+			if !changed {
+				continue
+			}
+			changed = false
+
+			// symlink may point to other directory now
+			if err := fc.updateWatches(); err != nil {
+				fc.Report(&opt.DriverError{Driver: "file", Op: "watch", Err: err})
 			}
+			fc.sourceChanged()
 		case event, ok := <-fc.watcher.Events:
 			if !ok {
 				return
 			}
-			changes <- event
+			if fc.isChanged(event) {
+				changed = true
+			}
 		case err, ok := <-fc.watcher.Errors:
 			if !ok {
 				return
 			}
 			if err != nil {
-				// TODO: display error
+				fc.Report(&opt.DriverError{Driver: "file", Op: "watch", Err: err})
+			}
+		}
+	}
+}
+
+// updateWatches watches directory of the files and directories of their
+// symlink targets. Watching directories instead of the file survives atomic
+// saves (write and rename) and symlink swaps, e.g. Kubernetes ConfigMap.
+func (fc *fileConnector) updateWatches() error {
+	files, err := fc.files()
+	if err != nil {
+		return err
+	}
+	dirs := make(map[string]bool)
+	if fc.op.Directory != "" {
+		dirs[filepath.Clean(fc.op.Directory)] = true
+	}
+	fc.links = make(map[string]string, len(files))
+	for _, name := range files {
+		dirs[filepath.Dir(name)] = true
+		realPath, _ := filepath.EvalSymlinks(name)
+		if realPath != "" {
+			dirs[filepath.Dir(realPath)] = true
+		}
+		fc.links[name] = realPath
+	}
+
+	for dir := range fc.dirs {
+		if !dirs[dir] {
+			// directory may be removed already
+			fc.watcher.Remove(dir)
+		}
+	}
+	for dir := range dirs {
+		if !fc.dirs[dir] {
+			if err := fc.watcher.Add(dir); err != nil {
+				return err
 			}
 		}
 	}
+	fc.dirs = dirs
+
+	return nil
+}
+
+// isChanged returns true if event in watched directories affects the files
+func (fc *fileConnector) isChanged(ev fsnotify.Event) bool {
+	name := filepath.Clean(ev.Name)
+	if _, ok := fc.links[name]; ok {
+		return true
+	}
+
+	// watched directory removed, it is watched again on update
+	if fc.dirs[name] && ev.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+		delete(fc.dirs, name)
+		return true
+	}
+
+	// fragment added to directory
+	if fc.op.Directory != "" && filepath.Dir(name) == filepath.Clean(fc.op.Directory) && fc.isFragment(name) {
+		return true
+	}
+
+	for fileName, target := range fc.links {
+		if name == target {
+			return true
+		}
+
+		// symlink in the path swapped
+		realPath, _ := filepath.EvalSymlinks(fileName)
+		if realPath != target {
+			return true
+		}
+	}
+	return false
 }
 
 // open the file
@@ -125,16 +243,13 @@ func (fc *fileConnector) openFile() error {
 		return errors.New("unsupported format " + fc.op.Format)
 	}
 
-	// try to open file
-	fd, err := os.Open(fc.op.FileName)
+	// try to open file or directory
+	fd, err := os.Open(fc.source())
 	if err != nil {
 		return err
 	}
 	defer fd.Close()
-
-	// watch configuration
-	fc.watcher, err = fsnotify.NewWatcher()
-	if err != nil {
+	if _, err := fc.files(); err != nil {
 		return err
 	}
 
@@ -143,26 +258,258 @@ func (fc *fileConnector) openFile() error {
 		return nil
 	}
 
+	// watch configuration, fallback to polling if fsnotify is not available
+	if fc.op.Watch != watchPoll {
+		err := fc.startWatcher()
+		if err == nil || fc.op.Watch == watchFsnotify {
+			return err
+		}
+		fc.Report(&opt.DriverError{Driver: "file", Op: "watch", Err: err})
+	}
+	rev, _ := fc.revision()
+	fc.done = make(chan bool, 1)
+	go fc.pollFileChanged(rev)
+
+	return nil
+}
+
+// startWatcher starts fsnotify watching loop
+func (fc *fileConnector) startWatcher() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	fc.watcher = watcher
+	if err := fc.updateWatches(); err != nil {
+		fc.watcher.Close()
+		fc.watcher = nil
+		fc.dirs = nil
+		return err
+	}
+
 	// start watching loop
 	fc.done = make(chan bool, 1)
 	go fc.watchFileChanged()
 
-	// watch config file
-	return fc.watcher.Add(fc.op.FileName)
+	return nil
+}
+
+// sourceChanged informs handler that configuration source changed
+func (fc *fileConnector) sourceChanged() {
+	// change made by Store
+	if fc.isSelfWrite() {
+		return
+	}
+
+	f := opt.SourceModified
+	if _, err := os.Stat(fc.source()); os.IsNotExist(err) {
+		f = opt.SourceRemoved
+	}
+	if err := fc.handler(f); err != nil {
+		fc.Report(&opt.DriverError{Driver: "file", Op: "reload", Err: err})
+	}
 }
 
 // Load read configuration from file
 func (fc *fileConnector) Load() (*opt.Options, error) {
-	// load configuration from file
-	return opt.FromFile(fc.op.FileName, fc.op.Format)
+	return fc.LoadContext(context.Background())
+}
+
+// LoadContext read configuration from file if the context is not done
+func (fc *fileConnector) LoadContext(ctx context.Context) (*opt.Options, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// load configuration from file or directory
+	op, err := fc.load()
+	if err != nil {
+		return nil, err
+	}
+
+	// verify detached signature
+	if fc.pubKey != nil {
+		sig, err := ioutil.ReadFile(fc.op.SignatureFile)
+		if err != nil {
+			return nil, err
+		}
+		if err := opt.Verify(op, fc.pubKey, string(sig)); err != nil {
+			return nil, err
+		}
+	}
+
+	return op, nil
+}
+
+// LoadVersion read configuration from file, the revision is composed of
+// file modification time and content hash
+func (fc *fileConnector) LoadVersion(ctx context.Context) (*opt.Options, string, error) {
+	// revision is taken first, if the file changed while loading,
+	// next StoreVersion fails instead of overwriting the change
+	rev, err := fc.revision()
+	if err != nil {
+		return nil, "", err
+	}
+	op, err := fc.LoadContext(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	return op, rev, nil
+}
+
+// StoreVersion save configuration to file if the file revision is not changed
+func (fc *fileConnector) StoreVersion(ctx context.Context, v *opt.Options, revision string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	if revision != "" {
+		rev, err := fc.revision()
+		if err != nil {
+			return "", err
+		}
+		if rev != revision {
+			return "", opt.ErrConflict
+		}
+	}
+	if err := fc.store(v); err != nil {
+		return "", err
+	}
+	return fc.revision()
+}
+
+// revision returns current file revision, in directory mode the latest
+// modification time and hash of all files
+func (fc *fileConnector) revision() (string, error) {
+	files, err := fc.files()
+	if err != nil {
+		return "", err
+	}
+	var modTime int64
+	h := sha256.New()
+	for _, name := range files {
+		fi, err := os.Stat(name)
+		if err != nil {
+			return "", err
+		}
+		if t := fi.ModTime().UnixNano(); t > modTime {
+			modTime = t
+		}
+		content, err := ioutil.ReadFile(name)
+		if err != nil {
+			return "", err
+		}
+		if fc.op.Directory != "" {
+			h.Write([]byte(name))
+		}
+		h.Write(content)
+	}
+	return fmt.Sprintf("%d-%x", modTime, h.Sum(nil)[:8]), nil
 }
 
 // Store save configuration to file
 func (fc *fileConnector) Store(v *opt.Options) error {
+	return fc.StoreContext(context.Background(), v)
+}
+
+// StoreContext save configuration to file if the context is not done
+func (fc *fileConnector) StoreContext(ctx context.Context, v *opt.Options) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	return fc.store(v)
+}
+
+// store writes configuration to file, fc.mu must be held
+func (fc *fileConnector) store(v *opt.Options) error {
 	if v == nil {
 		return errors.New("fileConnector: config parameter is nil")
 	}
-	return opt.ToFile(v, fc.op.FileName, fc.op.Format)
+	if fc.op.Directory != "" {
+		return errors.New("fileConnector: store is not supported in directory mode")
+	}
+	if fc.source() != fc.op.FileName || strings.EqualFold(filepath.Ext(fc.op.FileName), ".gz") {
+		return errors.New("fileConnector: store is not supported for compressed file")
+	}
+	// current content is backed up only if the new one is written
+	prev, err := fc.current()
+	if err != nil {
+		return err
+	}
+	if err := opt.ToFile(v, fc.op.FileName, fc.op.Format); err != nil {
+		return err
+	}
+	if err := fc.backup(prev); err != nil {
+		return err
+	}
+
+	// remember written content, so watcher does not reload own change
+	if fc.handler != nil {
+		content, err := ioutil.ReadFile(fc.op.FileName)
+		if err == nil {
+			sum := sha256.Sum256(content)
+			fc.selfHash = hex.EncodeToString(sum[:])
+		}
+	}
+	return nil
+}
+
+// current returns content of the file to be backed up,
+// nil if backups are disabled or the file does not exist
+func (fc *fileConnector) current() ([]byte, error) {
+	if fc.op.Backups <= 0 {
+		return nil, nil
+	}
+	content, err := ioutil.ReadFile(fc.op.FileName)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return content, err
+}
+
+// backup rotates numbered backups fileName.1 ... fileName.N and writes
+// previous content to fileName.1
+func (fc *fileConnector) backup(content []byte) error {
+	if content == nil {
+		return nil
+	}
+	for i := fc.op.Backups - 1; i >= 1; i-- {
+		err := os.Rename(fmt.Sprintf("%s.%d", fc.op.FileName, i), fmt.Sprintf("%s.%d", fc.op.FileName, i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	perm := os.FileMode(0644)
+	if fi, err := os.Stat(fc.op.FileName); err == nil {
+		perm = fi.Mode().Perm()
+	}
+	return ioutil.WriteFile(fc.op.FileName+".1", content, perm)
+}
+
+// isSelfWrite returns true if the file content is written by Store.
+// The hash is checked once, later change of the file is reloaded.
+func (fc *fileConnector) isSelfWrite() bool {
+	fc.mu.Lock()
+	selfHash := fc.selfHash
+	fc.selfHash = ""
+	fc.mu.Unlock()
+
+	if selfHash == "" {
+		return false
+	}
+	content, err := ioutil.ReadFile(fc.op.FileName)
+	if err != nil {
+		return false
+	}
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:]) == selfHash
 }
 
 // Close file connection
@@ -0,0 +1,346 @@
+package file
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ipsusila/opt"
+)
+
+// connectTest connects file driver, source flags passed to handler are
+// delivered to the returned channel
+func connectTest(t *testing.T, prop *opt.Options) (opt.Connector, chan int) {
+	t.Helper()
+	prop.Set("eventDelay", "50ms")
+	events := make(chan int, 10)
+	conn, err := (&fileDriver{}).Connect(func(f int) error {
+		events <- f
+		return nil
+	}, prop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn, events
+}
+
+func expectEvent(t *testing.T, events chan int, flag int) {
+	t.Helper()
+	select {
+	case f := <-events:
+		if f != flag {
+			t.Fatalf("Expecting source flag %d, got %d", flag, f)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Handler must be called with source flag %d", flag)
+	}
+}
+
+func expectNoEvent(t *testing.T, events chan int) {
+	t.Helper()
+	select {
+	case f := <-events:
+		t.Fatalf("Unexpected source flag %d", f)
+	case <-time.After(300 * time.Millisecond):
+	}
+}
+
+// drainEvents discards events delivered within next event delay
+func drainEvents(events chan int) {
+	for {
+		select {
+		case <-events:
+		case <-time.After(200 * time.Millisecond):
+			return
+		}
+	}
+}
+
+func expectValue(t *testing.T, conn opt.Connector, key string, val int) {
+	t.Helper()
+	op, err := conn.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := op.GetInt(key, 0); v != val {
+		t.Fatalf("Expecting %s=%d, got %d", key, val, v)
+	}
+}
+
+func writeFile(t *testing.T, name, content string) {
+	t.Helper()
+	if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func tempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "opt-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestWatchFile(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "config.json")
+	writeFile(t, fileName, `{"a": 1}`)
+
+	prop := opt.New()
+	prop.Set("fileName", fileName)
+	prop.Set("watch", watchFsnotify)
+	conn, events := connectTest(t, prop)
+	defer conn.Close()
+	expectValue(t, conn, "a", 1)
+
+	// in place write, truncate and write may be reported separately
+	writeFile(t, fileName, `{"a": 2}`)
+	expectEvent(t, events, opt.SourceModified)
+	drainEvents(events)
+	expectValue(t, conn, "a", 2)
+
+	// atomic save, the watched file is replaced
+	for i := 3; i <= 4; i++ {
+		tmp := filepath.Join(dir, "config.json.tmp")
+		writeFile(t, tmp, fmt.Sprintf(`{"a": %d}`, i))
+		if err := os.Rename(tmp, fileName); err != nil {
+			t.Fatal(err)
+		}
+		expectEvent(t, events, opt.SourceModified)
+		expectValue(t, conn, "a", i)
+	}
+
+	// other file in the directory
+	writeFile(t, filepath.Join(dir, "other.json"), `{"a": 0}`)
+	expectNoEvent(t, events)
+
+	if err := os.Remove(fileName); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(t, events, opt.SourceRemoved)
+}
+
+// swapData emulates Kubernetes ConfigMap update: files are written to new
+// hidden directory, then ..data symlink is atomically replaced
+func swapData(t *testing.T, dir, version, content string) {
+	t.Helper()
+	if err := os.Mkdir(filepath.Join(dir, version), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(dir, version, "config.json"), content)
+
+	old, _ := os.Readlink(filepath.Join(dir, "..data"))
+	tmp := filepath.Join(dir, "..data_tmp")
+	if err := os.Symlink(version, tmp); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, "..data")); err != nil {
+		t.Fatal(err)
+	}
+	if old != "" {
+		os.RemoveAll(filepath.Join(dir, old))
+	}
+}
+
+func TestWatchConfigMap(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	swapData(t, dir, "..v1", `{"a": 1}`)
+	fileName := filepath.Join(dir, "config.json")
+	if err := os.Symlink(filepath.Join("..data", "config.json"), fileName); err != nil {
+		t.Fatal(err)
+	}
+
+	prop := opt.New()
+	prop.Set("fileName", fileName)
+	prop.Set("watch", watchFsnotify)
+	conn, events := connectTest(t, prop)
+	defer conn.Close()
+	expectValue(t, conn, "a", 1)
+
+	// the watch follows new target directory after each swap
+	swapData(t, dir, "..v2", `{"a": 2}`)
+	expectEvent(t, events, opt.SourceModified)
+	expectValue(t, conn, "a", 2)
+
+	swapData(t, dir, "..v3", `{"a": 3}`)
+	expectEvent(t, events, opt.SourceModified)
+	expectValue(t, conn, "a", 3)
+}
+
+func TestStoreBackups(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "config.json")
+	writeFile(t, fileName, `{"a": 1}`)
+
+	prop := opt.New()
+	prop.Set("fileName", fileName)
+	prop.Set("backups", 2)
+	conn, err := (&fileDriver{}).Connect(nil, prop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	for i := 2; i <= 4; i++ {
+		op := opt.New()
+		op.Set("a", i)
+		if err := conn.Store(op); err != nil {
+			t.Fatal(err)
+		}
+	}
+	backupValue := func(n int) int {
+		op, err := opt.FromFile(fmt.Sprintf("%s.%d", fileName, n), opt.FormatJSON)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return op.GetInt("a", 0)
+	}
+	expectValue(t, conn, "a", 4)
+	if backupValue(1) != 3 || backupValue(2) != 2 {
+		t.Fatalf("Unexpected backups %d, %d", backupValue(1), backupValue(2))
+	}
+	if _, err := os.Stat(fileName + ".3"); !os.IsNotExist(err) {
+		t.Fatalf("Only %d backups must be kept", 2)
+	}
+
+	// failed write must not rotate backups
+	op := opt.New()
+	op.Set("a", make(chan int))
+	if err := conn.Store(op); err == nil {
+		t.Fatalf("Store of invalid value must fail")
+	}
+	expectValue(t, conn, "a", 4)
+	if backupValue(1) != 3 || backupValue(2) != 2 {
+		t.Fatalf("Backups rotated after failed write, got %d, %d", backupValue(1), backupValue(2))
+	}
+}
+
+func TestStoreSelfWrite(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "config.json")
+	writeFile(t, fileName, `{"a": 1}`)
+
+	prop := opt.New()
+	prop.Set("fileName", fileName)
+	prop.Set("watch", watchFsnotify)
+	conn, events := connectTest(t, prop)
+	defer conn.Close()
+
+	// own write is not reported
+	op := opt.New()
+	op.Set("a", 2)
+	if err := conn.Store(op); err != nil {
+		t.Fatal(err)
+	}
+	expectNoEvent(t, events)
+
+	// later external change is reported
+	writeFile(t, fileName, `{"a": 3}`)
+	expectEvent(t, events, opt.SourceModified)
+	drainEvents(events)
+	expectValue(t, conn, "a", 3)
+}
+
+func TestSignature(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileName := filepath.Join(dir, "config.json")
+	sign := func(content string) {
+		op, err := opt.FromText(content, opt.FormatJSON)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg, err := op.Canonical()
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeFile(t, fileName+".sig", base64.StdEncoding.EncodeToString(ed25519.Sign(priv, msg)))
+		writeFile(t, fileName, content)
+	}
+	sign(`{"a": 1}`)
+
+	prop := opt.New()
+	prop.Set("fileName", fileName)
+	prop.Set("publicKey", base64.StdEncoding.EncodeToString(pub))
+	conn, err := (&fileDriver{}).Connect(nil, prop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	expectValue(t, conn, "a", 1)
+
+	// tampered file
+	writeFile(t, fileName, `{"a": 2}`)
+	if _, err := conn.Load(); err != opt.ErrInvalidSignature {
+		t.Fatalf("Expecting ErrInvalidSignature, got %v", err)
+	}
+
+	// linked file is not covered by the signature
+	sign(`{"a": 1, "db": "@db.json"}`)
+	if _, err := conn.Load(); err != opt.ErrLinkedFile {
+		t.Fatalf("Expecting ErrLinkedFile, got %v", err)
+	}
+
+	// missing signature
+	os.Remove(fileName + ".sig")
+	if _, err := conn.Load(); err == nil {
+		t.Fatalf("Configuration without signature must be rejected")
+	}
+}
+
+func TestStoreVersion(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "config.json")
+	writeFile(t, fileName, `{"a": 1}`)
+
+	prop := opt.New()
+	prop.Set("fileName", fileName)
+	c, err := (&fileDriver{}).Connect(nil, prop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	conn := c.(opt.VersionedConnector)
+
+	_, rev, err := conn.LoadVersion(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	op := opt.New()
+	op.Set("a", 2)
+	rev, err = conn.StoreVersion(context.Background(), op, rev)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// external write between load and store
+	writeFile(t, fileName, `{"a": 3}`)
+	op.Set("a", 4)
+	if _, err := conn.StoreVersion(context.Background(), op, rev); err != opt.ErrConflict {
+		t.Fatalf("Expecting ErrConflict, got %v", err)
+	}
+	expectValue(t, c, "a", 3)
+
+	// empty revision overwrites
+	if _, err := conn.StoreVersion(context.Background(), op, ""); err != nil {
+		t.Fatal(err)
+	}
+	expectValue(t, c, "a", 4)
+}
@@ -0,0 +1,36 @@
+package file
+
+import (
+	"time"
+)
+
+// watch modes
+const (
+	watchAuto     = "auto"
+	watchFsnotify = "fsnotify"
+	watchPoll     = "poll"
+)
+
+// pollFileChanged checks file revision (modification time and content hash)
+// every pollInterval, used when fsnotify is not available
+func (fc *fileConnector) pollFileChanged(last string) {
+	defer close(fc.done)
+
+	ticker := time.NewTicker(fc.op.PollInterval.Duration)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-fc.quit:
+			return
+		case <-ticker.C:
+			// revision is empty if the file is removed
+			rev, _ := fc.revision()
+			if rev == last {
+				continue
+			}
+			last = rev
+			fc.sourceChanged()
+		}
+	}
+}
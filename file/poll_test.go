@@ -0,0 +1,69 @@
+package file
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ipsusila/opt"
+)
+
+func TestPoll(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "config.json")
+	writeFile(t, fileName, `{"a": 1}`)
+
+	prop := opt.New()
+	prop.Set("fileName", fileName)
+	prop.Set("watch", watchPoll)
+	prop.Set("pollInterval", "20ms")
+	conn, events := connectTest(t, prop)
+	defer conn.Close()
+	if conn.(*fileConnector).watcher != nil {
+		t.Fatalf("fsnotify must not be used in poll mode")
+	}
+
+	// content change is detected even if modification time is equal
+	fi, err := os.Stat(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, fileName, `{"a": 2}`)
+	if err := os.Chtimes(fileName, fi.ModTime(), fi.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(t, events, opt.SourceModified)
+	drainEvents(events)
+	expectValue(t, conn, "a", 2)
+
+	// own write is not reported
+	op := opt.New()
+	op.Set("a", 3)
+	if err := conn.Store(op); err != nil {
+		t.Fatal(err)
+	}
+	expectNoEvent(t, events)
+
+	if err := os.Remove(fileName); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(t, events, opt.SourceRemoved)
+}
+
+func TestPollInterval(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "config.json")
+	writeFile(t, fileName, `{"a": 1}`)
+
+	for _, interval := range []string{"0s", "-1s"} {
+		prop := opt.New()
+		prop.Set("fileName", fileName)
+		prop.Set("watch", watchPoll)
+		prop.Set("pollInterval", interval)
+		if _, err := (&fileDriver{}).Connect(func(f int) error { return nil }, prop); err == nil {
+			t.Fatalf("pollInterval %s must be rejected", interval)
+		}
+	}
+}
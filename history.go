@@ -0,0 +1,82 @@
+package opt
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultHistorySize is the number of configurations kept by Configurator
+const DefaultHistorySize = 10
+
+// HistoryEntry is a configuration applied by Configurator
+type HistoryEntry struct {
+	Time    time.Time
+	Hash    string
+	Options *Options
+}
+
+// SetHistorySize sets the number of configurations kept in history,
+// zero or negative value disables the history.
+func (cfg *Configurator) SetHistorySize(n int) {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+
+	if n < 0 {
+		n = 0
+	}
+	cfg.historySize = n
+	if len(cfg.history) > n {
+		cfg.history = cfg.history[:n]
+	}
+}
+
+// History returns applied configurations, the newest (current) first
+func (cfg *Configurator) History() []HistoryEntry {
+	cfg.mu.RLock()
+	defer cfg.mu.RUnlock()
+
+	return append([]HistoryEntry(nil), cfg.history...)
+}
+
+// Rollback applies n-th previous configuration (1 is the previous one) to
+// registered objects and drops newer entries from the history. If store is
+// set to true, the reverted configuration is also saved to the source.
+func (cfg *Configurator) Rollback(n int, store bool) error {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+
+	if n <= 0 || n >= len(cfg.history) {
+		return errors.Errorf("configuration history %d not available", n)
+	}
+
+	history := append([]HistoryEntry(nil), cfg.history[n:]...)
+	entry := history[0]
+	if err := cfg.apply(entry.Options, false); err != nil {
+		return err
+	}
+	cfg.history = history
+
+	if store && cfg.conn != nil {
+		return cfg.store(context.Background(), entry.Options)
+	}
+	return nil
+}
+
+// addHistory records applied configuration, called with cfg.mu locked
+func (cfg *Configurator) addHistory(op *Options) {
+	if cfg.historySize <= 0 {
+		return
+	}
+	hash := op.Hash()
+	if len(cfg.history) > 0 && cfg.history[0].Hash == hash {
+		return
+	}
+
+	entry := HistoryEntry{Time: time.Now(), Hash: hash, Options: op}
+	cfg.history = append([]HistoryEntry{entry}, cfg.history...)
+	if len(cfg.history) > cfg.historySize {
+		cfg.history = cfg.history[:cfg.historySize]
+	}
+}
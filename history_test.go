@@ -0,0 +1,46 @@
+package opt
+
+import (
+	"testing"
+)
+
+func TestHistoryRollback(t *testing.T) {
+	cfg, conn := newTestConfigurator(t, `{"log": {"level": "info"}}`)
+	defer cfg.Close()
+	cfg.SetHistorySize(3)
+
+	logCfg := &testConfigurable{}
+	cfg.Register("log", logCfg)
+
+	for _, level := range []string{"debug", "warn", "error"} {
+		if err := conn.change(`{"log": {"level": "` + level + `"}}`); err != nil {
+			t.Fatal(err)
+		}
+	}
+	history := cfg.History()
+	if len(history) != 3 {
+		t.Fatalf("Expecting 3 history entries, got %d", len(history))
+	}
+	if v := history[0].Options.GetString("log.level", ""); v != "error" {
+		t.Fatalf("Newest entry must be current configuration, got %s", v)
+	}
+
+	if err := cfg.Rollback(1, true); err != nil {
+		t.Fatal(err)
+	}
+	if v := cfg.Get("log").GetString("level", ""); v != "warn" {
+		t.Fatalf("Expecting warn after rollback, got %s", v)
+	}
+	if v := logCfg.last.GetString("level", ""); v != "warn" {
+		t.Fatalf("Configurable must receive reverted configuration, got %s", v)
+	}
+	if conn.stored == nil || conn.stored.GetString("log.level", "") != "warn" {
+		t.Fatalf("Reverted configuration must be stored")
+	}
+	if n := len(cfg.History()); n != 2 {
+		t.Fatalf("Expecting 2 history entries, got %d", n)
+	}
+	if err := cfg.Rollback(2, false); err == nil {
+		t.Fatalf("Rollback beyond history must fail")
+	}
+}
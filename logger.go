@@ -0,0 +1,149 @@
+package opt
+
+import (
+	"log"
+	"sync"
+)
+
+// size of Errors channel buffer
+const errorsBufferSize = 16
+
+// Logger receives messages from Configurator, *log.Logger satisfies this interface
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// ErrorReporter is implemented by connectors which report errors occurred
+// in background (watching, polling, handler errors) to the given handler.
+// Nil error is passed when the source is readable again after errors.
+type ErrorReporter interface {
+	SetErrorHandler(h func(err error))
+}
+
+// stdLogger writes to standard log package
+type stdLogger struct{}
+
+func (stdLogger) Printf(format string, v ...interface{}) {
+	log.Printf(format, v...)
+}
+
+// DriverError is error reported by driver in background
+type DriverError struct {
+	Driver string
+	Op     string
+	Err    error
+}
+
+// Error returns error message
+func (e *DriverError) Error() string {
+	return "opt: " + e.Driver + " driver, " + e.Op + ": " + e.Err.Error()
+}
+
+// Unwrap returns underlying error
+func (e *DriverError) Unwrap() error {
+	return e.Err
+}
+
+// Cause returns underlying error, see github.com/pkg/errors
+func (e *DriverError) Cause() error {
+	return e.Err
+}
+
+// Reporter implements ErrorReporter and can be embedded in connectors.
+// Until handler is set, errors are written with standard log package.
+type Reporter struct {
+	mu      sync.Mutex
+	handler func(err error)
+	failing bool
+}
+
+// SetErrorHandler sets the error handler
+func (r *Reporter) SetErrorHandler(h func(err error)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.handler = h
+}
+
+// Report passes err to the error handler
+func (r *Reporter) Report(err error) {
+	r.mu.Lock()
+	h := r.handler
+	r.failing = true
+	r.mu.Unlock()
+
+	if h != nil {
+		h(err)
+	} else {
+		log.Printf("[OPT] %v", err)
+	}
+}
+
+// ReportSuccess informs the error handler that the source is read
+// successfully after errors were reported
+func (r *Reporter) ReportSuccess() {
+	r.mu.Lock()
+	h, failing := r.handler, r.failing
+	r.failing = false
+	r.mu.Unlock()
+
+	if failing && h != nil {
+		h(nil)
+	}
+}
+
+// SetLogger sets logger used for reporting errors, nil disables logging.
+// By default errors are written with standard log package.
+func (cfg *Configurator) SetLogger(l Logger) {
+	cfg.errMu.Lock()
+	defer cfg.errMu.Unlock()
+
+	cfg.logger = l
+}
+
+// SetErrorHandler sets function which is called for every error
+// reported by the connector, e.g. failed reload
+func (cfg *Configurator) SetErrorHandler(h func(err error)) {
+	cfg.errMu.Lock()
+	defer cfg.errMu.Unlock()
+
+	cfg.errHandler = h
+}
+
+// Errors returns channel of errors reported by the connector.
+// If the channel buffer is full, the error is dropped.
+func (cfg *Configurator) Errors() <-chan error {
+	cfg.errMu.Lock()
+	defer cfg.errMu.Unlock()
+
+	if cfg.errs == nil {
+		cfg.errs = make(chan error, errorsBufferSize)
+	}
+	return cfg.errs
+}
+
+// reportError passes err to logger, error handler and Errors channel
+func (cfg *Configurator) reportError(err error) {
+	if err == nil {
+		cfg.recordSourceOK()
+		return
+	}
+	cfg.recordError(err)
+
+	cfg.errMu.Lock()
+	logger, h, errs := cfg.logger, cfg.errHandler, cfg.errs
+	cfg.errMu.Unlock()
+
+	if logger != nil {
+		logger.Printf("[OPT] %v", err)
+	}
+	if h != nil {
+		h(err)
+	}
+	if errs != nil {
+		select {
+		case errs <- err:
+		default:
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package opt
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+type testLogger struct {
+	lines []string
+}
+
+func (tl *testLogger) Printf(format string, v ...interface{}) {
+	tl.lines = append(tl.lines, fmt.Sprintf(format, v...))
+}
+
+func TestErrorReporting(t *testing.T) {
+	cfg, conn := newTestConfigurator(t, `{"log": {"level": "info"}}`)
+	defer cfg.Close()
+
+	logger := &testLogger{}
+	cfg.SetLogger(logger)
+	handled := []error{}
+	cfg.SetErrorHandler(func(err error) {
+		handled = append(handled, err)
+	})
+	errs := cfg.Errors()
+
+	cause := errors.New("connection refused")
+	conn.Report(&DriverError{Driver: "test", Op: "get config", Err: cause})
+
+	if len(logger.lines) != 1 || len(handled) != 1 {
+		t.Fatalf("Error must be logged and handled, got %v %v", logger.lines, handled)
+	}
+	err := <-errs
+	de, ok := err.(*DriverError)
+	if !ok || de.Unwrap() != cause || de.Op != "get config" {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	t.Log(logger.lines[0])
+}
@@ -0,0 +1,33 @@
+package opt
+
+// Merge merges src into the options. Objects are merged recursively,
+// other values (including arrays) in src replace existing values.
+// Merge panics if options is frozen.
+func (o *Options) Merge(src *Options) {
+	if src == nil || o == src {
+		return
+	}
+	// src lock is released before o is locked, concurrent a.Merge(b)
+	// and b.Merge(a) would deadlock otherwise
+	srcOpt := src.Snapshot()
+
+	o.Lock()
+	defer o.Unlock()
+	o.mustNotFrozen("Merge")
+
+	o.options = mergeMap(o.options, srcOpt.options)
+}
+
+// mergeMap returns copy of dst with src merged into it, dst is not modified
+func mergeMap(dst, src map[string]interface{}) map[string]interface{} {
+	nm := copyMap(dst)
+	for key, val := range src {
+		sv, ok := val.(map[string]interface{})
+		if dv, isMap := nm[key].(map[string]interface{}); ok && isMap {
+			nm[key] = mergeMap(dv, sv)
+		} else {
+			nm[key] = copyValue(val)
+		}
+	}
+	return nm
+}
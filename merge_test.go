@@ -0,0 +1,58 @@
+package opt
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	base, _ := FromText(`{"log": {"level": "info", "file": "app.log"}, "hosts": ["a", "b"]}`, FormatJSON)
+	over, _ := FromText(`{"log": {"level": "debug"}, "hosts": ["c"], "port": 80}`, FormatJSON)
+	snap := base.Snapshot()
+
+	base.Merge(over)
+	if v := base.GetString("log.level", ""); v != "debug" {
+		t.Fatalf("Expecting debug, got %s", v)
+	}
+	if v := base.GetString("log.file", ""); v != "app.log" {
+		t.Fatalf("Objects must be merged, got %s", v)
+	}
+	if v := base.GetStringArray("hosts"); len(v) != 1 || v[0] != "c" {
+		t.Fatalf("Arrays must be replaced, got %v", v)
+	}
+	if base.GetInt("port", 0) != 80 {
+		t.Fatalf("New key must be added")
+	}
+	if v := snap.GetString("log.level", ""); v != "info" {
+		t.Fatalf("Merge must not modify shared maps, got %s", v)
+	}
+
+	// source is not shared with merged options
+	over.Set("log.level", "warn")
+	if v := base.GetString("log.level", ""); v != "debug" {
+		t.Fatalf("Merged options must not share source maps, got %s", v)
+	}
+}
+
+func TestMergeConcurrent(t *testing.T) {
+	a, _ := FromText(`{"a": 1}`, FormatJSON)
+	b, _ := FromText(`{"b": 2}`, FormatJSON)
+
+	// merging in both directions must not deadlock
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			a.Merge(b)
+		}()
+		go func() {
+			defer wg.Done()
+			b.Merge(a)
+		}()
+	}
+	wg.Wait()
+	if a.GetInt("b", 0) != 2 || b.GetInt("a", 0) != 1 {
+		t.Fatalf("Unexpected merge result %s, %s", a.AsJSON(), b.AsJSON())
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -47,6 +48,7 @@ var rplDelimiter = strings.NewReplacer(";", "\\;", "=", "\\=")
 type Options struct {
 	sync.RWMutex
 	filePath string
+	frozen   int32
 	options  map[string]interface{}
 }
 
@@ -118,7 +120,9 @@ func FromText(cfgText string, format string) (*Options, error) {
 	return FromReader(reader, format)
 }
 
-// ToFile saves configuration to file
+// ToFile saves configuration to file. The content is written to temporary file
+// in the same directory, synced and renamed, so the file is never partially written.
+// Permission of existing file is preserved.
 func ToFile(op *Options, filePath, format string) error {
 	ext := ""
 	if len(format) == 0 {
@@ -130,47 +134,103 @@ func ToFile(op *Options, filePath, format string) error {
 	if ext != FormatJSON && ext != FormatHJSON {
 		return errors.New("unsupported format " + ext)
 	}
+	if _, name := SplitArchivePath(filePath); name != "" || strings.EqualFold(path.Ext(filePath), ".gz") {
+		return errors.New("can not write to compressed file " + filePath)
+	}
 
-	// open configuration
-	f, err := os.Create(filePath)
+	content, err := ToText(op, ext)
 	if err != nil {
-		return errors.Wrapf(err, "failed to create file %s", filePath)
+		return err
 	}
-	defer f.Close()
 
-	switch ext {
+	return writeFile(filePath, []byte(content))
+}
+
+//ToText encodes options in given format (json or hjson)
+func ToText(op *Options, format string) (string, error) {
+	var content []byte
+	var err error
+	if op.rlock() {
+		defer op.RUnlock()
+	}
+	switch strings.ToLower(format) {
 	case FormatHJSON:
-		js, err := hjson.Marshal(op.options)
-		if err != nil {
-			return err
-		}
-		_, err = f.Write(js)
+		content, err = hjson.Marshal(op.options)
 	case FormatJSON:
-		js, err := json.MarshalIndent(op.options, "", "  ")
-		if err != nil {
-			return err
-		}
-		_, err = f.Write(js)
+		content, err = json.MarshalIndent(op.options, "", "  ")
+	default:
+		return "", errors.New("unsupported format " + format)
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "failed to marshal options")
+	}
+
+	return string(content), nil
+}
+
+// writeFile writes content atomically, see ToFile
+func writeFile(filePath string, content []byte) error {
+	// write to symlink target instead of replacing the symlink
+	if realPath, err := filepath.EvalSymlinks(filePath); err == nil {
+		filePath = realPath
+	}
+	perm := os.FileMode(0644)
+	if fi, err := os.Stat(filePath); err == nil {
+		perm = fi.Mode().Perm()
+	}
+
+	dir, name := filepath.Split(filePath)
+	if dir == "" {
+		dir = "."
+	}
+	f, err := ioutil.TempFile(dir, "."+name+".tmp")
+	if err != nil {
+		return errors.Wrapf(err, "failed to create file %s", filePath)
+	}
+	tmpName := f.Name()
+	defer os.Remove(tmpName)
+
+	_, err = f.Write(content)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmpName, perm)
+	}
+	if err == nil {
+		err = os.Rename(tmpName, filePath)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to write file %s", filePath)
 	}
 
+	// sync directory, so the rename is durable
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
 	return nil
 }
 
-//FromFile read options from given file
+//FromFile read options from given file. The file may be gzip compressed (config.json.gz)
+//or located inside archive (bundle.zip#app/config.hjson), format is taken from inner name.
 func FromFile(filePath string, format string) (*Options, error) {
+	f, name, err := openFile(filePath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open file %s", filePath)
+	}
+	defer f.Close()
+
 	ext := ""
 	if len(format) == 0 {
-		ext = strings.Trim(path.Ext(filePath), ".")
+		ext = strings.Trim(path.Ext(name), ".")
 	} else {
 		ext = format
 	}
 
-	f, err := os.Open(filePath)
-	if err != nil {
-		return nil, errors.Wrapf(err, "failed to open file %s", filePath)
-	}
-	defer f.Close()
-
 	o, err := FromReader(f, ext)
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to parse stream %s", filePath)
@@ -182,30 +242,24 @@ func FromFile(filePath string, format string) (*Options, error) {
 	return o, nil
 }
 
+//newContainer returns writable container for given key. Maps along the key path
+//are copied (copy-on-write), so maps shared with other options are never modified.
 func (o *Options) newContainer(key string) (map[string]interface{}, string) {
 	keyItems := strings.Split(key, ".")
-	nItems := len(keyItems)
-	if nItems <= 1 {
-		return o.options, key
-	}
+	ne := len(keyItems) - 1
 
-	ne := nItems - 1
+	o.options = copyMap(o.options)
 	mapItem := o.options
 	for k := 0; k < ne; k++ {
 		key = keyItems[k]
-		val, ok := mapItem[key]
-		if !ok {
-			newContainer := make(map[string]interface{})
-			mapItem[key] = newContainer
-			mapItem = newContainer
+		vm, ok := mapItem[key].(map[string]interface{})
+		if ok {
+			vm = copyMap(vm)
 		} else {
-			mapItem, ok = val.(map[string]interface{})
-			if !ok {
-				newContainer := make(map[string]interface{})
-				mapItem[key] = newContainer
-				mapItem = newContainer
-			}
+			vm = make(map[string]interface{})
 		}
+		mapItem[key] = vm
+		mapItem = vm
 	}
 
 	return mapItem, keyItems[ne]
@@ -309,43 +363,29 @@ func (o *Options) escape(val interface{}) string {
 	return string(rbuf[:rp])
 }
 
-// EqualTo returns true if two configuration is equal
+// EqualTo returns true if two configuration is structurally equal (see DeepEqual)
 func (o *Options) EqualTo(op *Options) bool {
 	if o == op {
 		return true
 	}
-	if op == nil {
-		return o == nil
-	}
-
-	// convert to json
-	js1, err := json.Marshal(o.options)
-	if err != nil {
-		return false
-	}
-	js2, err := json.Marshal(op.options)
-	if err != nil {
+	if op == nil || o == nil {
 		return false
 	}
 
-	n1 := len(js1)
-	n2 := len(js2)
-	if n1 != n2 {
-		return false
+	if o.rlock() {
+		defer o.RUnlock()
 	}
-
-	for i := 0; i < n1; i++ {
-		if js1[i] != js2[i] {
-			return false
-		}
+	if op.rlock() {
+		defer op.RUnlock()
 	}
-	return true
+	return DeepEqual(o.options, op.options)
 }
 
 //String converts options to string
 func (o *Options) String() string {
-	o.RLock()
-	defer o.RUnlock()
+	if o.rlock() {
+		defer o.RUnlock()
+	}
 
 	//convert to string
 	format := func(obj interface{}) string {
@@ -375,8 +415,9 @@ func (o *Options) String() string {
 
 //Format options for display
 func (o *Options) Format(optDelim string) string {
-	o.RLock()
-	defer o.RUnlock()
+	if o.rlock() {
+		defer o.RUnlock()
+	}
 
 	text := ""
 	fDelim := string(delimField)
@@ -468,12 +509,17 @@ func (o *Options) expandTo(vSrc map[string]interface{}, vMap map[string]interfac
 	*/
 }
 
-// ExpandAll expand linked properties and return it as map
+// ExpandAll expand linked properties and return it as map.
+// Frozen options is not modified, only the expanded map is returned.
 func (o *Options) ExpandAll() map[string]interface{} {
-	o.RLock()
-	defer o.RUnlock()
-
 	vMap := make(map[string]interface{})
+	if o.isFrozen() {
+		o.expandTo(o.options, vMap)
+		return vMap
+	}
+
+	o.Lock()
+	defer o.Unlock()
 	o.expandTo(o.options, vMap)
 	o.options = vMap
 
@@ -482,8 +528,9 @@ func (o *Options) ExpandAll() map[string]interface{} {
 
 //GetStringArray returns array of string
 func (o *Options) GetStringArray(key string) []string {
-	o.RLock()
-	defer o.RUnlock()
+	if o.rlock() {
+		defer o.RUnlock()
+	}
 
 	container, key := o.getContainer(key)
 	val, ok := container[key]
@@ -512,10 +559,12 @@ func (o *Options) GetStringArray(key string) []string {
 	return res
 }
 
-//GetString retrieves option value. If not exist, return default value
+//GetString retrieves option value. If not exist, return default value.
+//Secret reference (secret://...) is returned as is, use GetSecret to resolve it.
 func (o *Options) GetString(key, def string) string {
-	o.RLock()
-	defer o.RUnlock()
+	if o.rlock() {
+		defer o.RUnlock()
+	}
 
 	container, key := o.getContainer(key)
 	val, ok := container[key]
@@ -526,10 +575,26 @@ func (o *Options) GetString(key, def string) string {
 	return o.asText(val)
 }
 
+//GetSecret retrieves option value and resolves secret reference if any.
+//Unlike GetString, escape sequences are not replaced, the raw value is used.
+//The reference is resolved without holding the lock.
+func (o *Options) GetSecret(key string) (string, error) {
+	val, ok := o.GetObject(key)
+	if !ok {
+		return "", errors.Errorf("key %s not found", key)
+	}
+	str, ok := val.(string)
+	if !ok {
+		str = fmt.Sprintf("%v", val)
+	}
+	return ResolveSecret(str)
+}
+
 //GetDuration returns options as time.Duration
 func (o *Options) GetDuration(key string, def time.Duration) time.Duration {
-	o.RLock()
-	defer o.RUnlock()
+	if o.rlock() {
+		defer o.RUnlock()
+	}
 
 	container, key := o.getContainer(key)
 	val, ok := container[key]
@@ -583,8 +648,9 @@ func (o *Options) GetObjectArray(key string) []*Options {
 		return newOpt
 	*/
 
-	o.RLock()
-	defer o.RUnlock()
+	if o.rlock() {
+		defer o.RUnlock()
+	}
 
 	container, key := o.getContainer(key)
 	val, ok := container[key]
@@ -600,9 +666,7 @@ func (o *Options) GetObjectArray(key string) []*Options {
 	res := []*Options{}
 	for _, val := range va {
 		if v, ok := val.(map[string]interface{}); ok {
-			newOpt := &Options{
-				options: v,
-			}
+			newOpt := &Options{options: v}
 			res = append(res, newOpt)
 		}
 	}
@@ -612,8 +676,9 @@ func (o *Options) GetObjectArray(key string) []*Options {
 
 //GetInt64Array returns array of integer
 func (o *Options) GetInt64Array(key string) []int64 {
-	o.RLock()
-	defer o.RUnlock()
+	if o.rlock() {
+		defer o.RUnlock()
+	}
 
 	container, key := o.getContainer(key)
 	val, ok := container[key]
@@ -665,8 +730,9 @@ func (o *Options) GetInt64Array(key string) []int64 {
 
 //GetFloat64Array returns option as float64 array
 func (o *Options) GetFloat64Array(key string) []float64 {
-	o.RLock()
-	defer o.RUnlock()
+	if o.rlock() {
+		defer o.RUnlock()
+	}
 
 	container, key := o.getContainer(key)
 	val, ok := container[key]
@@ -718,8 +784,9 @@ func (o *Options) GetFloat64Array(key string) []float64 {
 
 //GetInt64 returns integer or default value if not exists
 func (o *Options) GetInt64(key string, def int64) int64 {
-	o.RLock()
-	defer o.RUnlock()
+	if o.rlock() {
+		defer o.RUnlock()
+	}
 
 	container, key := o.getContainer(key)
 	val, ok := container[key]
@@ -762,8 +829,9 @@ func (o *Options) GetInt64(key string, def int64) int64 {
 
 //GetInt returns integer or default value if not exists
 func (o *Options) GetInt(key string, def int) int {
-	o.RLock()
-	defer o.RUnlock()
+	if o.rlock() {
+		defer o.RUnlock()
+	}
 
 	container, key := o.getContainer(key)
 	val, ok := container[key]
@@ -806,8 +874,9 @@ func (o *Options) GetInt(key string, def int) int {
 
 //GetFloat returns decimal values or default value if not exist
 func (o *Options) GetFloat(key string, def float64) float64 {
-	o.RLock()
-	defer o.RUnlock()
+	if o.rlock() {
+		defer o.RUnlock()
+	}
 
 	container, key := o.getContainer(key)
 	val, ok := container[key]
@@ -850,8 +919,9 @@ func (o *Options) GetFloat(key string, def float64) float64 {
 
 //GetBool returns bool representation of given default value
 func (o *Options) GetBool(key string, def bool) bool {
-	o.RLock()
-	defer o.RUnlock()
+	if o.rlock() {
+		defer o.RUnlock()
+	}
 
 	container, key := o.getContainer(key)
 	val, ok := container[key]
@@ -873,20 +943,26 @@ func (o *Options) GetBool(key string, def bool) bool {
 	return vb
 }
 
-//GetObject return value as interface
+//GetObject return value as interface. Objects and arrays are copied,
+//so modification of the value does not affect the options.
 func (o *Options) GetObject(key string) (interface{}, bool) {
-	o.RLock()
-	defer o.RUnlock()
+	if o.rlock() {
+		defer o.RUnlock()
+	}
 
 	container, key := o.getContainer(key)
 	val, ok := container[key]
-	return val, ok
+	return copyValue(val), ok
 }
 
-//Get return map[string]interface{} item as options
+//Get return map[string]interface{} item as options. The returned options
+//shares data with its parent, modification is copy-on-write so Set on
+//returned options does not affect the parent (and vice versa).
+//The returned options is writable even if the parent is frozen.
 func (o *Options) Get(key string) *Options {
-	o.RLock()
-	defer o.RUnlock()
+	if o.rlock() {
+		defer o.RUnlock()
+	}
 
 	container, key := o.getContainer(key)
 	val, ok := container[key]
@@ -916,16 +992,18 @@ func (o *Options) Get(key string) *Options {
 
 //IsEmpty return true if options having no values
 func (o *Options) IsEmpty() bool {
-	o.RLock()
-	defer o.RUnlock()
+	if o.rlock() {
+		defer o.RUnlock()
+	}
 
 	return len(o.options) == 0
 }
 
 //Exists return true if key exist in options
 func (o *Options) Exists(key string) bool {
-	o.RLock()
-	defer o.RUnlock()
+	if o.rlock() {
+		defer o.RUnlock()
+	}
 
 	container, key := o.getContainer(key)
 	_, ok := container[key]
@@ -933,10 +1011,11 @@ func (o *Options) Exists(key string) bool {
 	return ok
 }
 
-//Set fill options with given key=val
+//Set fill options with given key=val. Set panics if options is frozen.
 func (o *Options) Set(key string, val interface{}) interface{} {
 	o.Lock()
 	defer o.Unlock()
+	o.mustNotFrozen("Set")
 
 	//navigate to container, if not exists, create one
 	container, key := o.newContainer(key)
@@ -949,10 +1028,11 @@ func (o *Options) Set(key string, val interface{}) interface{} {
 	return nil
 }
 
-//Assign configuration values
+//Assign configuration values. Assign panics if options is frozen.
 func (o *Options) Assign(optMap map[string]interface{}) {
 	o.Lock()
 	defer o.Unlock()
+	o.mustNotFrozen("Assign")
 
 	o.options = make(map[string]interface{})
 	for key, val := range optMap {
@@ -965,6 +1045,10 @@ func (o *Options) Assign(optMap map[string]interface{}) {
 func (o *Options) Parse(params string) (err error) {
 	o.Lock()
 	defer o.Unlock()
+	if o.isFrozen() {
+		return ErrFrozen
+	}
+	o.options = copyMap(o.options)
 
 	//parser state
 	const (
@@ -2,6 +2,9 @@ package opt
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/davecgh/go-spew/spew"
@@ -357,3 +360,44 @@ func TestFunctionality(t *testing.T) {
 	//format?
 	t.Logf("Format: %v", op.Format("\n"))
 }
+
+func TestToFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "opt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fileName := filepath.Join(dir, "config.json")
+	if err := ioutil.WriteFile(fileName, []byte(`{}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	op := New()
+	op.Set("log.level", "debug")
+	if err := ToFile(op, fileName, FormatAuto); err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := os.Stat(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Fatalf("File permission must be preserved, got %v", fi.Mode())
+	}
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 {
+		t.Fatalf("Temporary file must be removed, got %d files", len(files))
+	}
+	nop, err := FromFile(fileName, FormatAuto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !nop.EqualTo(op) {
+		t.Fatalf("Expecting %v, got %v", op, nop)
+	}
+
+	if err := ToFile(op, filepath.Join(dir, "missing", "config.json"), FormatAuto); err == nil {
+		t.Fatalf("Write error must be returned")
+	}
+}
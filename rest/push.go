@@ -0,0 +1,164 @@
+package rest
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ipsusila/opt"
+)
+
+// change monitoring modes
+const (
+	modePoll     = "poll"
+	modeSSE      = "sse"
+	modeLongPoll = "longpoll"
+)
+
+// minimum duration of long-poll request regarded as held by the server,
+// shorter request is repeated after retry delay
+const minLongPollHold = time.Second
+
+// startPush starts goroutine waiting for changes announced by the server
+// until ctx is cancelled
+func (rc *restConnector) startPush(ctx context.Context, cancel context.CancelFunc) {
+	rc.cancel = cancel
+	rc.done = make(chan struct{})
+
+	go func() {
+		defer close(rc.done)
+		rc.push(ctx)
+	}()
+}
+
+// push keeps connection to the server, reconnecting with backoff
+// when the connection fails
+func (rc *restConnector) push(ctx context.Context) {
+	// no timeout, the connection is held until the server responds
+	client := &http.Client{
+		Transport: rc.client.Transport,
+	}
+	delay := rc.op.RetryDelay.Duration
+	etag := ""
+	for {
+		var err error
+		connected := false
+		start := time.Now()
+		if rc.op.Mode == modeSSE {
+			connected, err = rc.readEvents(ctx, client)
+		} else {
+			connected, etag, err = rc.longPoll(ctx, client, etag)
+		}
+		held := time.Since(start) >= minLongPollHold
+		if ctx.Err() != nil {
+			return
+		}
+		if connected {
+			delay = rc.op.RetryDelay.Duration
+		}
+		if err != nil {
+			rc.Report(&opt.DriverError{Driver: "rest", Op: "push", Err: err})
+		} else if rc.op.Mode == modeLongPoll && etag != "" && held {
+			// the server holds requests, next one is sent immediately
+			continue
+		}
+
+		// reconnect after delay, so closed stream does not cause busy loop
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		if err != nil {
+			delay *= 2
+			if delay > rc.op.MaxRetryDelay.Duration {
+				delay = rc.op.MaxRetryDelay.Duration
+			}
+		}
+	}
+}
+
+// newPushRequest creates request to push endpoint
+func (rc *restConnector) newPushRequest(ctx context.Context) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", rc.op.PushURI, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := rc.setAuth(req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// readEvents reads Server-Sent Events stream, every event announces
+// new configuration version. Returns when the stream is closed.
+func (rc *restConnector) readEvents(ctx context.Context, client *http.Client) (bool, error) {
+	req, err := rc.newPushRequest(ctx)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Cache-Control", "no-cache")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if !isSuccess(resp.StatusCode) {
+		return false, newStatusError(resp)
+	}
+
+	// configuration may be changed while disconnected
+	rc.check(ctx)
+
+	// event is terminated by empty line, comments start with colon
+	event := false
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if event {
+				event = false
+				rc.check(ctx)
+			}
+		case strings.HasPrefix(line, ":"):
+		default:
+			event = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return true, err
+	}
+	return true, nil
+}
+
+// longPoll waits until the server responds. The server holds the request
+// until the version differs from If-None-Match or responds 304 on timeout.
+func (rc *restConnector) longPoll(ctx context.Context, client *http.Client, etag string) (bool, string, error) {
+	req, err := rc.newPushRequest(ctx)
+	if err != nil {
+		return false, etag, err
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, etag, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return true, etag, nil
+	}
+	if !isSuccess(resp.StatusCode) {
+		return false, etag, newStatusError(resp)
+	}
+	rc.check(ctx)
+
+	return true, resp.Header.Get("ETag"), nil
+}
@@ -0,0 +1,209 @@
+package rest
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ipsusila/opt"
+)
+
+// config server announcing new version through SSE and long-poll
+type pushServer struct {
+	mu      sync.Mutex
+	version int
+	notify  chan bool
+}
+
+func (ps *pushServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ps.mu.Lock()
+	version := ps.version
+	ps.mu.Unlock()
+
+	switch r.URL.Path {
+	case "/events":
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.(http.Flusher).Flush()
+		for {
+			select {
+			case <-ps.notify:
+				fmt.Fprint(w, ": comment\n\nevent: version\ndata: changed\n\n")
+				w.(http.Flusher).Flush()
+			case <-r.Context().Done():
+				return
+			}
+		}
+	case "/poll":
+		etag := fmt.Sprintf(`"%d"`, version)
+		if r.Header.Get("If-None-Match") == etag {
+			select {
+			case <-ps.notify:
+				ps.mu.Lock()
+				etag = fmt.Sprintf(`"%d"`, ps.version)
+				ps.mu.Unlock()
+			case <-time.After(100 * time.Millisecond):
+				w.WriteHeader(http.StatusNotModified)
+				return
+			case <-r.Context().Done():
+				return
+			}
+		}
+		w.Header().Set("ETag", etag)
+	default:
+		fmt.Fprintf(w, `{"version": %d}`, version)
+	}
+}
+
+func (ps *pushServer) publish() {
+	ps.mu.Lock()
+	ps.version++
+	ps.mu.Unlock()
+
+	// held request is notified, otherwise next request gets new version
+	select {
+	case ps.notify <- true:
+	default:
+	}
+}
+
+func TestPush(t *testing.T) {
+	ps := &pushServer{version: 1, notify: make(chan bool, 1)}
+	srv := httptest.NewServer(ps)
+	defer srv.Close()
+
+	for mode, path := range map[string]string{modeSSE: "/events", modeLongPoll: "/poll"} {
+		prop := opt.New()
+		prop.Set("uri", srv.URL)
+		prop.Set("mode", mode)
+		prop.Set("pushURI", srv.URL+path)
+
+		changed := make(chan bool, 1)
+		conn, err := (&restDriver{}).Connect(func(f int) error {
+			select {
+			case changed <- true:
+			default:
+			}
+			return nil
+		}, prop)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 2; i++ {
+			if _, err := conn.Load(); err != nil {
+				t.Fatal(err)
+			}
+			ps.publish()
+			select {
+			case <-changed:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("%s: handler must be called on announced change", mode)
+			}
+		}
+		conn.Close()
+	}
+}
+
+func TestPushClose(t *testing.T) {
+	var mu sync.Mutex
+	hang := false
+	started := make(chan bool, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		h := hang
+		mu.Unlock()
+		if r.URL.Path == "/poll" {
+			// change is announced once the configuration request hangs
+			if !h || r.Header.Get("If-None-Match") != "" {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("ETag", `"2"`)
+			return
+		}
+		if h {
+			// configuration request is held until cancelled
+			started <- true
+			select {
+			case <-r.Context().Done():
+			case <-time.After(10 * time.Second):
+			}
+			return
+		}
+		w.Write([]byte(`{"version": 1}`))
+	}))
+	defer srv.Close()
+
+	prop := opt.New()
+	prop.Set("uri", srv.URL)
+	prop.Set("mode", modeLongPoll)
+	prop.Set("pushURI", srv.URL+"/poll")
+	prop.Set("timeout", "10s")
+	prop.Set("retryDelay", "10ms")
+	conn, err := (&restDriver{}).Connect(func(f int) error { return nil }, prop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	hang = true
+	mu.Unlock()
+
+	// first long-poll response triggers configuration request
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Configuration must be requested after announced change")
+	}
+	closed := make(chan bool)
+	go func() {
+		conn.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Close must cancel in-flight request")
+	}
+}
+
+func TestPushNotHeld(t *testing.T) {
+	var mu sync.Mutex
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/poll" {
+			w.Write([]byte(`{"version": 1}`))
+			return
+		}
+		mu.Lock()
+		requests++
+		mu.Unlock()
+
+		// server without long-poll support responds immediately
+		if r.Header.Get("If-None-Match") == `"1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"1"`)
+	}))
+	defer srv.Close()
+
+	prop := opt.New()
+	prop.Set("uri", srv.URL)
+	prop.Set("mode", modeLongPoll)
+	prop.Set("pushURI", srv.URL+"/poll")
+	prop.Set("retryDelay", "50ms")
+	conn, err := (&restDriver{}).Connect(func(f int) error { return nil }, prop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(300 * time.Millisecond)
+	conn.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if requests > 10 {
+		t.Fatalf("Requests not held by the server must be delayed, got %d requests", requests)
+	}
+}
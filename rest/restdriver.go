@@ -1,8 +1,10 @@
 package rest
 
 import (
+	"context"
+	"crypto/ed25519"
+	"errors"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"strings"
 	"sync"
@@ -19,6 +21,31 @@ type driverOptions struct {
 	Username string       `json:"username"`
 	Password string       `json:"password"`
 	Timeout  opt.Duration `json:"timeout"`
+
+	Conditional bool `json:"conditional"`
+
+	Mode    string `json:"mode"`
+	PushURI string `json:"pushURI"`
+
+	CAFile             string            `json:"caFile"`
+	CertFile           string            `json:"certFile"`
+	KeyFile            string            `json:"keyFile"`
+	InsecureSkipVerify bool              `json:"insecureSkipVerify"`
+	Token              string            `json:"token"`
+	TokenFile          string            `json:"tokenFile"`
+	Headers            map[string]string `json:"headers"`
+	Proxy              string            `json:"proxy"`
+
+	StoreMethod string `json:"storeMethod"`
+	ContentType string `json:"contentType"`
+	MergePatch  bool   `json:"mergePatch"`
+
+	Retries       int          `json:"retries"`
+	RetryDelay    opt.Duration `json:"retryDelay"`
+	MaxRetryDelay opt.Duration `json:"maxRetryDelay"`
+
+	PublicKey       string `json:"publicKey"`
+	SignatureHeader string `json:"signatureHeader"`
 }
 
 //restx driver configuration
@@ -27,11 +54,33 @@ type restDriver struct {
 
 //restx connector
 type restConnector struct {
+	opt.Reporter
 	mu       sync.Mutex
 	handler  func(f int) error
 	op       driverOptions
-	lastConf string
+	lastHash string
 	c        *cron.Cron
+	pubKey   ed25519.PublicKey
+	client   *http.Client
+	token    *tokenSource
+
+	// cancels change monitoring, done is closed when push goroutine ends
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	// last response, used for conditional request
+	cache *response
+
+	// last loaded or stored configuration, used for merge patch
+	last *opt.Options
+}
+
+// response of configuration request
+type response struct {
+	content      string
+	header       http.Header
+	etag         string
+	lastModified string
 }
 
 // register restx driver
@@ -42,127 +91,384 @@ func init() {
 // Connect to the configuration source. Connection must includes:
 // - format			: string*
 // - uri			: string*
-// - cronSpec		: string*
-// - username		: string*
-// - password		: string*
+// - cronSpec		: string (poll mode, changes are not monitored if empty)
+// - mode			: string (poll, sse or longpoll, default poll)
+// - pushURI		: string (SSE or long-poll endpoint, default uri)
+// - username		: string* (may be secret reference, e.g. secret://env/USER)
+// - password		: string* (may be secret reference, e.g. secret://file/run/secrets/pass)
+// - publicKey		: string (base64 ed25519 key, when set signature is verified on load, @file links are rejected)
+// - signatureHeader: string (response header containing signature, default X-Config-Signature)
+// - conditional	: bool (send If-None-Match/If-Modified-Since, default true)
+// - caFile		: string (PEM CA bundle used to verify the server)
+// - certFile		: string (PEM client certificate for mutual TLS)
+// - keyFile		: string (PEM client key for mutual TLS)
+// - insecureSkipVerify: bool (do not verify server certificate, for development only)
+// - token			: string (bearer token, may be secret reference)
+// - tokenFile		: string (file containing bearer token, read again when modified)
+// - headers		: object (additional request headers, values may be secret reference)
+// - proxy			: string (proxy URL, default from HTTP_PROXY/HTTPS_PROXY environment)
+// - storeMethod	: string (POST, PUT or PATCH, default POST or PATCH for merge patch)
+// - contentType	: string (content type of stored configuration, default by format)
+// - mergePatch		: bool (store JSON merge patch of changes since last load)
+// - retries		: int (retries of failed request, default 2)
+// - retryDelay		: duration (initial delay between retries, default 500ms, doubled on every retry)
+// - maxRetryDelay	: duration (default 10s)
 func (dd *restDriver) Connect(h func(f int) error, prop *opt.Options) (opt.Connector, error) {
+	return dd.ConnectContext(context.Background(), h, prop)
+}
+
+// ConnectContext connects to the configuration source, see Connect for options.
+// The context is used for the initial request only.
+func (dd *restDriver) ConnectContext(ctx context.Context, h func(f int) error, prop *opt.Options) (opt.Connector, error) {
 	op := driverOptions{
-		Format:  opt.FormatJSON,
-		Timeout: opt.Duration{Duration: 10 * time.Second},
+		Format:          opt.FormatJSON,
+		Timeout:         opt.Duration{Duration: 10 * time.Second},
+		SignatureHeader: "X-Config-Signature",
+		Conditional:     true,
+		Retries:         2,
+		RetryDelay:      opt.Duration{Duration: 500 * time.Millisecond},
+		MaxRetryDelay:   opt.Duration{Duration: 10 * time.Second},
+		Mode:            modePoll,
 	}
 	if err := prop.AsStruct(&op); err != nil {
 		return nil, err
 	}
+	if op.Mode != modePoll && op.Mode != modeSSE && op.Mode != modeLongPoll {
+		return nil, errors.New("unsupported mode " + op.Mode)
+	}
+	switch strings.ToUpper(op.StoreMethod) {
+	case "", "POST", "PUT", "PATCH":
+	default:
+		return nil, errors.New("unsupported store method " + op.StoreMethod)
+	}
+	if op.PushURI == "" {
+		op.PushURI = op.URI
+	}
 
+	tr, err := newTransport(&op)
+	if err != nil {
+		return nil, err
+	}
 	rc := &restConnector{
 		op:      op,
 		handler: h,
+		c:       cron.New(),
+		client: &http.Client{
+			Timeout:   op.Timeout.Duration,
+			Transport: tr,
+		},
+		token: &tokenSource{
+			token:    op.Token,
+			fileName: op.TokenFile,
+		},
+	}
+	if op.PublicKey != "" {
+		key, err := opt.ParsePublicKey(op.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		rc.pubKey = key
 	}
-	if err := rc.connect(); err != nil {
+	if err := rc.connect(ctx); err != nil {
 		return nil, err
 	}
 	return rc, nil
 }
 
-func (rc *restConnector) connect() error {
+func (rc *restConnector) connect(ctx context.Context) error {
 	// try to connect
-	_, err := rc.getConfig()
+	_, _, _, err := rc.getConfig(ctx)
 	if err != nil {
 		return err
 	}
 
-	// monitor database change using cron
-	if rc.handler != nil {
-		rc.c.AddFunc(rc.op.CronSpec, func() {
-			// get last loaded config
-			rc.mu.Lock()
-			lastConfig := rc.lastConf
-			rc.mu.Unlock()
+	if rc.handler == nil || (rc.op.Mode == modePoll && rc.op.CronSpec == "") {
+		return nil
+	}
+
+	// requests of change monitoring are cancelled on Close
+	watchCtx, cancel := context.WithCancel(context.Background())
 
-			// read config from REST server
-			config, err := rc.getConfig()
-			if err == nil && lastConfig != "" && config != lastConfig {
-				if err := rc.handler(opt.SourceModified); err != nil {
-					log.Printf("[OPT] restDriver cron, handler error: %v", err)
-				}
-			} else {
-				log.Printf("[OPT] restDriver cron, get config error: %v", err)
-			}
-		})
+	// wait for change announced by the server
+	if rc.op.Mode != modePoll {
+		rc.startPush(watchCtx, cancel)
+		return nil
 	}
+
+	// monitor configuration change using cron
+	_, err = rc.c.AddFunc(rc.op.CronSpec, func() {
+		rc.check(watchCtx)
+	})
+	if err != nil {
+		cancel()
+		return err
+	}
+	rc.cancel = cancel
+	rc.c.Start()
+
 	return nil
 }
 
-func (rc *restConnector) getConfig() (string, error) {
-	client := &http.Client{
-		Timeout: rc.op.Timeout.Duration,
+// check reads configuration and informs handler if it is changed.
+// The request is cancelled with ctx when the connector is closed.
+func (rc *restConnector) check(ctx context.Context) {
+	// get last loaded config
+	rc.mu.Lock()
+	lastHash := rc.lastHash
+	rc.mu.Unlock()
+
+	// read config from REST server
+	config, _, modified, err := rc.getConfig(ctx)
+	if ctx.Err() != nil {
+		return
 	}
-	req, err := http.NewRequest("GET", rc.op.URI, nil)
 	if err != nil {
-		return "", err
+		rc.Report(&opt.DriverError{Driver: "rest", Op: "get config", Err: err})
+		return
+	}
+	if !modified {
+		rc.ReportSuccess()
+		return
+	}
+	op, err := opt.FromText(config, rc.op.Format)
+	if err != nil {
+		rc.Report(&opt.DriverError{Driver: "rest", Op: "parse config", Err: err})
+		return
+	}
+	rc.ReportSuccess()
+	if lastHash != "" && op.Hash() != lastHash {
+		if err := rc.handler(opt.SourceModified); err != nil {
+			rc.Report(&opt.DriverError{Driver: "rest", Op: "reload", Err: err})
+		}
+	}
+}
+
+// getConfig returns configuration content and response header. If server
+// responds 304 Not Modified, last content is returned and modified is false.
+// Failed request is retried.
+func (rc *restConnector) getConfig(ctx context.Context) (content string, header http.Header, modified bool, err error) {
+	err = rc.retry(ctx, isTemporary, func() error {
+		var err error
+		content, header, modified, err = rc.getConfigOnce(ctx)
+		return err
+	})
+	return
+}
+
+func (rc *restConnector) getConfigOnce(ctx context.Context) (string, http.Header, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", rc.op.URI, nil)
+	if err != nil {
+		return "", nil, false, err
 	}
 	req.Close = true
-	if rc.op.Username != "" && rc.op.Password != "" {
-		req.SetBasicAuth(rc.op.Username, rc.op.Password)
+	if err := rc.setAuth(req); err != nil {
+		return "", nil, false, err
+	}
+
+	// conditional request
+	rc.mu.Lock()
+	cache := rc.cache
+	rc.mu.Unlock()
+	if cache != nil {
+		if cache.etag != "" {
+			req.Header.Set("If-None-Match", cache.etag)
+		}
+		if cache.lastModified != "" {
+			req.Header.Set("If-Modified-Since", cache.lastModified)
+		}
 	}
 
 	// execute request
-	resp, err := client.Do(req)
+	resp, err := rc.client.Do(req)
 	if resp != nil {
 		defer resp.Body.Close()
 	}
 	if err != nil {
-		return "", err
+		return "", nil, false, err
+	}
+	if resp.StatusCode == http.StatusNotModified && cache != nil {
+		return cache.content, cache.header, false, nil
+	}
+	if !isSuccess(resp.StatusCode) {
+		return "", nil, false, newStatusError(resp)
 	}
 	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", nil, false, err
+	}
 
-	return string(content), err
+	// keep response for next conditional request
+	if rc.op.Conditional {
+		cache = &response{
+			content:      string(content),
+			header:       resp.Header,
+			etag:         resp.Header.Get("ETag"),
+			lastModified: resp.Header.Get("Last-Modified"),
+		}
+		if cache.etag != "" || cache.lastModified != "" {
+			rc.mu.Lock()
+			rc.cache = cache
+			rc.mu.Unlock()
+		}
+	}
+
+	return string(content), resp.Header, true, nil
+}
+
+// setAuth set custom headers, bearer token or basic authentication.
+// Header values, token, username and password may be secret reference.
+func (rc *restConnector) setAuth(req *http.Request) error {
+	for key, val := range rc.op.Headers {
+		val, err := opt.ResolveSecret(val)
+		if err != nil {
+			return err
+		}
+		req.Header.Set(key, val)
+	}
+
+	if rc.op.Token != "" || rc.op.TokenFile != "" {
+		token, err := rc.token.Token()
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		return nil
+	}
+
+	if rc.op.Username == "" || rc.op.Password == "" {
+		return nil
+	}
+	username, err := opt.ResolveSecret(rc.op.Username)
+	if err != nil {
+		return err
+	}
+	password, err := opt.ResolveSecret(rc.op.Password)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(username, password)
+
+	return nil
 }
 
 // Load read configuration from restx. The database must be in JSON format
 func (rc *restConnector) Load() (*opt.Options, error) {
-	content, err := rc.getConfig()
+	return rc.LoadContext(context.Background())
+}
+
+// LoadContext read configuration from restx, request is bound to the context
+func (rc *restConnector) LoadContext(ctx context.Context) (*opt.Options, error) {
+	op, _, err := rc.LoadVersion(ctx)
+	return op, err
+}
+
+// LoadVersion read configuration from restx, the revision is the ETag
+// returned by the server. If the server does not send ETag, the revision
+// is empty, so StoreVersion can not detect concurrent change.
+func (rc *restConnector) LoadVersion(ctx context.Context) (*opt.Options, string, error) {
+	content, header, _, err := rc.getConfig(ctx)
 	if err != nil {
-		return nil, err
+		return nil, "", err
+	}
+	op, err := opt.FromText(content, rc.op.Format)
+	if err != nil {
+		return nil, "", err
+	}
+	if rc.pubKey != nil {
+		if err := opt.Verify(op, rc.pubKey, header.Get(rc.op.SignatureHeader)); err != nil {
+			return nil, "", err
+		}
 	}
 	rc.mu.Lock()
-	rc.lastConf = content
+	rc.lastHash = op.Hash()
+	rc.last = op.Snapshot()
 	rc.mu.Unlock()
 
-	return opt.FromText(rc.lastConf, rc.op.Format)
+	return op, header.Get("ETag"), nil
 }
 
 // Store save configuration to restx
 func (rc *restConnector) Store(v *opt.Options) error {
-	client := &http.Client{
-		Timeout: rc.op.Timeout.Duration,
-	}
-	rd := strings.NewReader(v.AsJSON())
-	req, err := http.NewRequest("POST", rc.op.URI, rd)
+	return rc.StoreContext(context.Background(), v)
+}
+
+// StoreContext save configuration to restx, request is bound to the context
+func (rc *restConnector) StoreContext(ctx context.Context, v *opt.Options) error {
+	_, err := rc.StoreVersion(ctx, v, "")
+	return err
+}
+
+// StoreVersion save configuration to restx using configured method and encoding
+// (format or merge patch) with If-Match header set to the
+// revision. Server responding 412 Precondition Failed results in ErrConflict,
+// other non 2xx status in StatusError. Failed PUT or PATCH request is retried,
+// POST only if the server did not process it, see isRetryableStore.
+func (rc *restConnector) StoreVersion(ctx context.Context, v *opt.Options, revision string) (etag string, err error) {
+	method, body, contentType, err := rc.storeRequest(v)
 	if err != nil {
+		return "", err
+	}
+	retryable := func(err error) bool {
+		return isRetryableStore(method, err)
+	}
+	err = rc.retry(ctx, retryable, func() error {
+		var err error
+		etag, err = rc.storeOnce(ctx, v, method, body, contentType, revision)
 		return err
+	})
+	return
+}
+
+func (rc *restConnector) storeOnce(ctx context.Context, v *opt.Options, method, body, contentType, revision string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, method, rc.op.URI, strings.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if revision != "" {
+		req.Header.Set("If-Match", revision)
 	}
-	req.Header.Set("Content-Type", "application/json")
 	req.Close = true
-	if rc.op.Username != "" && rc.op.Password != "" {
-		req.SetBasicAuth(rc.op.Username, rc.op.Password)
+	if err := rc.setAuth(req); err != nil {
+		return "", err
 	}
 
 	// execute request
-	resp, err := client.Do(req)
+	resp, err := rc.client.Do(req)
 	if resp != nil {
 		defer resp.Body.Close()
 	}
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode == http.StatusPreconditionFailed {
+		return "", opt.ErrConflict
+	}
+	if !isSuccess(resp.StatusCode) {
+		return "", newStatusError(resp)
+	}
 
-	return err
+	// configuration changed, next request must not be conditional
+	rc.mu.Lock()
+	rc.cache = nil
+	rc.last = v.Snapshot()
+	rc.mu.Unlock()
+
+	return resp.Header.Get("ETag"), nil
 }
 
 // Close restx connection
 func (rc *restConnector) Close() error {
+	if rc.cancel != nil {
+		rc.cancel()
+	}
 	if rc.c != nil {
 		ctx := rc.c.Stop()
 		<-ctx.Done()
 	}
+	if rc.done != nil {
+		<-rc.done
+	}
 
 	return nil
 }
@@ -0,0 +1,194 @@
+package rest
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/ipsusila/opt"
+)
+
+// config server supporting conditional requests
+type conditionalServer struct {
+	mu              sync.Mutex
+	version         int
+	notModified     int
+	ifNoneMatch     string
+	ifModifiedSince string
+}
+
+func (cs *conditionalServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	cs.ifNoneMatch = r.Header.Get("If-None-Match")
+	cs.ifModifiedSince = r.Header.Get("If-Modified-Since")
+	etag := fmt.Sprintf(`"%d"`, cs.version)
+	lastModified := fmt.Sprintf("Mon, 0%d Jan 2024 00:00:00 GMT", cs.version)
+	if cs.ifNoneMatch == etag {
+		cs.notModified++
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", lastModified)
+	fmt.Fprintf(w, `{"version": %d}`, cs.version)
+}
+
+func TestConditional(t *testing.T) {
+	cs := &conditionalServer{version: 1}
+	srv := httptest.NewServer(cs)
+	defer srv.Close()
+
+	prop := opt.New()
+	prop.Set("uri", srv.URL)
+	prop.Set("cronSpec", "@every 1h")
+	changed := 0
+	conn, err := (&restDriver{}).Connect(func(f int) error {
+		changed++
+		return nil
+	}, prop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	rc := conn.(*restConnector)
+
+	// validators of last response are sent, 304 returns cached content
+	for i := 1; i <= 2; i++ {
+		op, err := conn.Load()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if op.GetInt("version", 0) != 1 {
+			t.Fatalf("Expecting cached configuration, got %s", op.AsJSON())
+		}
+		cs.mu.Lock()
+		ifNoneMatch, ifModifiedSince, notModified := cs.ifNoneMatch, cs.ifModifiedSince, cs.notModified
+		cs.mu.Unlock()
+		if ifNoneMatch != `"1"` || ifModifiedSince != "Mon, 01 Jan 2024 00:00:00 GMT" {
+			t.Fatalf("Expecting conditional request, got %q, %q", ifNoneMatch, ifModifiedSince)
+		}
+		if notModified != i {
+			t.Fatalf("Expecting %d not modified responses, got %d", i, notModified)
+		}
+	}
+
+	// polling does not inform handler on 304
+	rc.check(context.Background())
+	if changed != 0 {
+		t.Fatalf("Handler must not be called for not modified configuration")
+	}
+
+	cs.mu.Lock()
+	cs.version = 2
+	cs.mu.Unlock()
+	rc.check(context.Background())
+	if changed != 1 {
+		t.Fatalf("Handler must be called for modified configuration")
+	}
+
+	// content of modified response is cached
+	cs.mu.Lock()
+	notModified := cs.notModified
+	cs.mu.Unlock()
+	op, err := conn.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if op.GetInt("version", 0) != 2 || cs.notModified != notModified+1 {
+		t.Fatalf("Expecting cached version 2, got %s", op.AsJSON())
+	}
+}
+
+func TestConditionalDisabled(t *testing.T) {
+	cs := &conditionalServer{version: 1}
+	srv := httptest.NewServer(cs)
+	defer srv.Close()
+
+	prop := opt.New()
+	prop.Set("uri", srv.URL)
+	prop.Set("conditional", false)
+	conn, err := (&restDriver{}).Connect(nil, prop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Load(); err != nil {
+		t.Fatal(err)
+	}
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if cs.ifNoneMatch != "" || cs.ifModifiedSince != "" || cs.notModified != 0 {
+		t.Fatalf("Request must not be conditional")
+	}
+}
+
+func TestConnectWithoutCron(t *testing.T) {
+	srv := httptest.NewServer(&conditionalServer{version: 1})
+	defer srv.Close()
+
+	// change monitoring is disabled without cronSpec in poll mode
+	prop := opt.New()
+	prop.Set("uri", srv.URL)
+	conn, err := (&restDriver{}).Connect(func(f int) error { return nil }, prop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
+
+func TestSignature(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	content, signed := `{"a": 1}`, `{"a": 1}`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		op, _ := opt.FromText(signed, opt.FormatJSON)
+		msg, _ := op.Canonical()
+		w.Header().Set("X-Config-Signature", base64.StdEncoding.EncodeToString(ed25519.Sign(priv, msg)))
+		w.Write([]byte(content))
+	}))
+	defer srv.Close()
+
+	prop := opt.New()
+	prop.Set("uri", srv.URL)
+	prop.Set("publicKey", base64.StdEncoding.EncodeToString(pub))
+	conn, err := (&restDriver{}).Connect(nil, prop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	// tampered response
+	mu.Lock()
+	content = `{"a": 2}`
+	mu.Unlock()
+	if _, err := conn.Load(); err != opt.ErrInvalidSignature {
+		t.Fatalf("Expecting ErrInvalidSignature, got %v", err)
+	}
+
+	// linked file is not covered by the signature
+	mu.Lock()
+	content, signed = `{"db": "@db.json"}`, `{"db": "@db.json"}`
+	mu.Unlock()
+	if _, err := conn.Load(); err != opt.ErrLinkedFile {
+		t.Fatalf("Expecting ErrLinkedFile, got %v", err)
+	}
+}
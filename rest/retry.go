@@ -0,0 +1,110 @@
+package rest
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// maximum length of response body kept in StatusError
+const maxBodyExcerpt = 512
+
+// StatusError is returned when server responds with non 2xx status
+type StatusError struct {
+	StatusCode int
+	Status     string
+	Body       string
+}
+
+// Error returns error message
+func (e *StatusError) Error() string {
+	msg := "rest: server responded " + e.Status
+	if e.Status == "" {
+		msg = "rest: server responded " + strconv.Itoa(e.StatusCode)
+	}
+	if e.Body != "" {
+		msg += ": " + e.Body
+	}
+	return msg
+}
+
+// Temporary returns true if the request may succeed when retried
+func (e *StatusError) Temporary() bool {
+	return e.StatusCode >= 500 || e.StatusCode == http.StatusTooManyRequests ||
+		e.StatusCode == http.StatusRequestTimeout
+}
+
+// newStatusError creates StatusError with excerpt of response body
+func newStatusError(resp *http.Response) *StatusError {
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxBodyExcerpt))
+	return &StatusError{
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Body:       string(body),
+	}
+}
+
+// isSuccess returns true for 2xx status
+func isSuccess(code int) bool {
+	return code >= 200 && code < 300
+}
+
+// retry calls fn until it succeeds, returns error not accepted by retryable
+// or number of retries exceeded. Delay between retries grows exponentially
+// with jitter.
+func (rc *restConnector) retry(ctx context.Context, retryable func(err error) bool, fn func() error) error {
+	delay := rc.op.RetryDelay.Duration
+	for i := 0; ; i++ {
+		err := fn()
+		if err == nil || i >= rc.op.Retries || !retryable(err) {
+			return err
+		}
+
+		// wait between delay/2 and delay
+		wait := delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+		delay *= 2
+		if delay > rc.op.MaxRetryDelay.Duration {
+			delay = rc.op.MaxRetryDelay.Duration
+		}
+	}
+}
+
+// isTemporary returns true for connection errors, timeouts and temporary
+// status errors. Certificate errors are not retried.
+func isTemporary(err error) bool {
+	if e, ok := err.(*StatusError); ok {
+		return e.Temporary()
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// isRetryableStore returns true if store request with given method may be
+// retried. PUT and PATCH are retried as GET. POST is not idempotent, it is
+// retried only if the server did not receive or explicitly rejected the
+// request: connection refused, 429 Too Many Requests or 503 Service Unavailable.
+func isRetryableStore(method string, err error) bool {
+	if method == "PUT" || method == "PATCH" {
+		return isTemporary(err)
+	}
+	if e, ok := err.(*StatusError); ok {
+		return e.StatusCode == http.StatusTooManyRequests || e.StatusCode == http.StatusServiceUnavailable
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
@@ -0,0 +1,166 @@
+package rest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ipsusila/opt"
+)
+
+// server failing configured number of requests with given status
+type failingServer struct {
+	mu       sync.Mutex
+	failures int
+	status   int
+	delay    time.Duration
+	requests []time.Time
+}
+
+func (fs *failingServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fs.mu.Lock()
+	fs.requests = append(fs.requests, time.Now())
+	fail := len(fs.requests) <= fs.failures
+	status, delay := fs.status, fs.delay
+	fs.mu.Unlock()
+
+	if !fail {
+		w.Write([]byte(`{"a": 1}`))
+		return
+	}
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+		}
+		return
+	}
+	w.WriteHeader(status)
+}
+
+// reset sets failures of next requests
+func (fs *failingServer) reset(failures, status int, delay time.Duration) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	fs.failures, fs.status, fs.delay = failures, status, delay
+	fs.requests = nil
+}
+
+func (fs *failingServer) count() int {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	return len(fs.requests)
+}
+
+func connectRetry(t *testing.T, uri, storeMethod string) opt.Connector {
+	t.Helper()
+	prop := opt.New()
+	prop.Set("uri", uri)
+	prop.Set("storeMethod", storeMethod)
+	prop.Set("retries", 2)
+	prop.Set("retryDelay", "40ms")
+	prop.Set("timeout", "100ms")
+	conn, err := (&restDriver{}).Connect(nil, prop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+func TestRetry(t *testing.T) {
+	fs := &failingServer{}
+	srv := httptest.NewServer(fs)
+	defer srv.Close()
+	conn := connectRetry(t, srv.URL, "")
+	defer conn.Close()
+
+	// temporary failures are retried with growing delay
+	fs.reset(2, http.StatusServiceUnavailable, 0)
+	if _, err := conn.Load(); err != nil {
+		t.Fatal(err)
+	}
+	fs.mu.Lock()
+	requests := fs.requests
+	fs.mu.Unlock()
+	if len(requests) != 3 {
+		t.Fatalf("Expecting 3 requests, got %d", len(requests))
+	}
+	if d := requests[1].Sub(requests[0]); d < 20*time.Millisecond {
+		t.Fatalf("First retry must wait at least half of retryDelay, waited %v", d)
+	}
+	if d := requests[2].Sub(requests[1]); d < 40*time.Millisecond {
+		t.Fatalf("Retry delay must be doubled, waited %v", d)
+	}
+
+	// number of retries exceeded
+	fs.reset(3, http.StatusBadGateway, 0)
+	_, err := conn.Load()
+	if se, ok := err.(*StatusError); !ok || se.StatusCode != http.StatusBadGateway {
+		t.Fatalf("Expecting StatusError, got %v", err)
+	}
+	if n := fs.count(); n != 3 {
+		t.Fatalf("Expecting 3 requests, got %d", n)
+	}
+
+	// client errors are not retried
+	for _, status := range []int{http.StatusUnauthorized, http.StatusNotFound} {
+		fs.reset(1, status, 0)
+		if _, err := conn.Load(); err == nil {
+			t.Fatalf("Expecting error for status %d", status)
+		}
+		if n := fs.count(); n != 1 {
+			t.Fatalf("Status %d must not be retried, got %d requests", status, n)
+		}
+	}
+
+	// timeout is retried
+	fs.reset(1, 0, time.Second)
+	if _, err := conn.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if n := fs.count(); n != 2 {
+		t.Fatalf("Expecting 2 requests, got %d", n)
+	}
+}
+
+func TestRetryStore(t *testing.T) {
+	fs := &failingServer{}
+	srv := httptest.NewServer(fs)
+	defer srv.Close()
+	post := connectRetry(t, srv.URL, "POST")
+	defer post.Close()
+	put := connectRetry(t, srv.URL, "PUT")
+	defer put.Close()
+
+	tests := []struct {
+		conn     opt.Connector
+		status   int
+		delay    time.Duration
+		requests int
+	}{
+		// POST may be already processed
+		{post, http.StatusInternalServerError, 0, 1},
+		{post, http.StatusBadGateway, 0, 1},
+		{post, 0, time.Second, 1},
+		// POST rejected by the server
+		{post, http.StatusServiceUnavailable, 0, 2},
+		{post, http.StatusTooManyRequests, 0, 2},
+		// PUT is idempotent
+		{put, http.StatusInternalServerError, 0, 2},
+		{put, 0, time.Second, 2},
+	}
+	for i, tt := range tests {
+		fs.reset(1, tt.status, tt.delay)
+		err := tt.conn.Store(opt.New())
+		if n := fs.count(); n != tt.requests {
+			t.Fatalf("#%d: expecting %d requests, got %d", i, tt.requests, n)
+		}
+		if (tt.requests == 1) != (err != nil) {
+			t.Fatalf("#%d: unexpected result %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package rest
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+
+	"github.com/ipsusila/opt"
+)
+
+// storeRequest returns method, body and content type of store request
+func (rc *restConnector) storeRequest(v *opt.Options) (string, string, string, error) {
+	method := strings.ToUpper(rc.op.StoreMethod)
+	if rc.op.MergePatch {
+		if method == "" {
+			method = "PATCH"
+		}
+		rc.mu.Lock()
+		last := rc.last
+		rc.mu.Unlock()
+
+		body, err := mergePatch(last, v)
+		return method, body, rc.contentType("application/merge-patch+json"), err
+	}
+
+	if method == "" {
+		method = "POST"
+	}
+	if strings.ToLower(rc.op.Format) == opt.FormatHJSON {
+		body, err := opt.ToText(v, opt.FormatHJSON)
+		return method, body, rc.contentType("application/hjson"), err
+	}
+	body, err := opt.ToText(v, opt.FormatJSON)
+	return method, body, rc.contentType("application/json"), err
+}
+
+// contentType returns configured content type or def
+func (rc *restConnector) contentType(def string) string {
+	if rc.op.ContentType != "" {
+		return rc.op.ContentType
+	}
+	return def
+}
+
+// mergePatch returns JSON merge patch (RFC 7386) transforming oldOp into newOp.
+// If oldOp is nil, the patch contains whole newOp.
+func mergePatch(oldOp, newOp *opt.Options) (string, error) {
+	var oldMap, newMap map[string]interface{}
+	if oldOp != nil {
+		if err := json.Unmarshal([]byte(oldOp.AsJSON()), &oldMap); err != nil {
+			return "", err
+		}
+	}
+	if err := json.Unmarshal([]byte(newOp.AsJSON()), &newMap); err != nil {
+		return "", err
+	}
+	patch, err := json.Marshal(diffPatch(oldMap, newMap))
+	if err != nil {
+		return "", err
+	}
+	return string(patch), nil
+}
+
+// diffPatch returns changed values, removed keys are set to null
+func diffPatch(oldMap, newMap map[string]interface{}) map[string]interface{} {
+	patch := make(map[string]interface{})
+	for key := range oldMap {
+		if _, ok := newMap[key]; !ok {
+			patch[key] = nil
+		}
+	}
+	for key, nv := range newMap {
+		ov, ok := oldMap[key]
+		om, isOldMap := ov.(map[string]interface{})
+		nm, isNewMap := nv.(map[string]interface{})
+		switch {
+		case isOldMap && isNewMap:
+			if sub := diffPatch(om, nm); len(sub) > 0 {
+				patch[key] = sub
+			}
+		case !ok || !reflect.DeepEqual(ov, nv):
+			patch[key] = nv
+		}
+	}
+	return patch
+}
@@ -0,0 +1,122 @@
+package rest
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/ipsusila/opt"
+)
+
+func TestStore(t *testing.T) {
+	var method, contentType, body string
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			w.Write([]byte(`{"log": {"level": "info", "file": "app.log"}, "port": 80}`))
+			return
+		}
+		content, _ := ioutil.ReadAll(r.Body)
+		method, contentType, body = r.Method, r.Header.Get("Content-Type"), string(content)
+		w.WriteHeader(status)
+		w.Write([]byte("read-only configuration"))
+	}))
+	defer srv.Close()
+
+	prop := opt.New()
+	prop.Set("uri", srv.URL)
+	prop.Set("mergePatch", true)
+	prop.Set("retries", 0)
+	conn, err := (&restDriver{}).Connect(nil, prop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	op, err := conn.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	op = op.Clone()
+	op.Set("log.level", "debug")
+	op.Set("port", nil)
+	if err := conn.Store(op); err != nil {
+		t.Fatal(err)
+	}
+	if method != "PATCH" || contentType != "application/merge-patch+json" {
+		t.Fatalf("Expecting merge patch, got %s %s", method, contentType)
+	}
+	if body != `{"log":{"level":"debug"},"port":null}` {
+		t.Fatalf("Unexpected patch %s", body)
+	}
+
+	status = http.StatusForbidden
+	err = conn.(opt.ContextConnector).StoreContext(context.Background(), op)
+	if se, ok := err.(*StatusError); !ok || se.StatusCode != http.StatusForbidden || se.Body != "read-only configuration" {
+		t.Fatalf("Expecting StatusError, got %v", err)
+	}
+}
+
+func TestStoreVersion(t *testing.T) {
+	var mu sync.Mutex
+	version, ifMatch := 1, ""
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		etag := fmt.Sprintf(`"%d"`, version)
+		if r.Method == "GET" {
+			w.Header().Set("ETag", etag)
+			fmt.Fprintf(w, `{"version": %d}`, version)
+			return
+		}
+		ifMatch = r.Header.Get("If-Match")
+		if ifMatch != "" && ifMatch != etag {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		version++
+		w.Header().Set("ETag", fmt.Sprintf(`"%d"`, version))
+	}))
+	defer srv.Close()
+
+	prop := opt.New()
+	prop.Set("uri", srv.URL)
+	prop.Set("storeMethod", "PUT")
+	c, err := (&restDriver{}).Connect(nil, prop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	conn := c.(opt.VersionedConnector)
+
+	op, rev, err := conn.LoadVersion(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rev != `"1"` {
+		t.Fatalf("Revision must be the ETag, got %q", rev)
+	}
+	rev, err = conn.StoreVersion(context.Background(), op, rev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	sent := ifMatch
+	mu.Unlock()
+	if sent != `"1"` || rev != `"2"` {
+		t.Fatalf("Expecting If-Match \"1\" and new revision \"2\", got %q, %q", sent, rev)
+	}
+
+	// changed by other client
+	mu.Lock()
+	version++
+	mu.Unlock()
+	if _, err := conn.StoreVersion(context.Background(), op, rev); err != opt.ErrConflict {
+		t.Fatalf("Expecting ErrConflict, got %v", err)
+	}
+}
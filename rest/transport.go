@@ -0,0 +1,92 @@
+package rest
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipsusila/opt"
+)
+
+// newTransport creates HTTP transport with TLS and proxy settings
+func newTransport(op *driverOptions) (*http.Transport, error) {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+
+	// proxy, default from environment
+	if op.Proxy != "" {
+		proxyURL, err := url.Parse(op.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		tr.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if op.CAFile == "" && op.CertFile == "" && !op.InsecureSkipVerify {
+		return tr, nil
+	}
+	cfg := &tls.Config{
+		InsecureSkipVerify: op.InsecureSkipVerify,
+	}
+	if op.CAFile != "" {
+		pem, err := ioutil.ReadFile(op.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificate found in " + op.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+
+	// client certificate for mutual TLS
+	if op.CertFile != "" || op.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(op.CertFile, op.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	tr.TLSClientConfig = cfg
+
+	return tr, nil
+}
+
+// tokenSource returns bearer token, token file is read again when modified
+type tokenSource struct {
+	mu       sync.Mutex
+	token    string
+	fileName string
+	modTime  time.Time
+}
+
+// Token returns current token
+func (ts *tokenSource) Token() (string, error) {
+	if ts.fileName == "" {
+		return opt.ResolveSecret(ts.token)
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	fi, err := os.Stat(ts.fileName)
+	if err != nil {
+		return "", err
+	}
+	if ts.token == "" || !fi.ModTime().Equal(ts.modTime) {
+		content, err := ioutil.ReadFile(ts.fileName)
+		if err != nil {
+			return "", err
+		}
+		ts.token = strings.TrimSpace(string(content))
+		ts.modTime = fi.ModTime()
+	}
+	return ts.token, nil
+}
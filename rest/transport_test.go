@@ -0,0 +1,260 @@
+package rest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ipsusila/opt"
+)
+
+// writeClientCert creates self signed client certificate and key files,
+// returns the certificate
+func writeClientCert(t *testing.T, dir string) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePEM(t, filepath.Join(dir, "client.crt"), "CERTIFICATE", der)
+	writePEM(t, filepath.Join(dir, "client.key"), "EC PRIVATE KEY", keyDer)
+
+	return cert
+}
+
+func writePEM(t *testing.T, fileName, blockType string, der []byte) {
+	t.Helper()
+	content := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := ioutil.WriteFile(fileName, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "opt-rest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// server requires client certificate
+	clientCert := writeClientCert(t, dir)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 || r.TLS.PeerCertificates[0].Subject.CommonName != "client" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"a": 1}`))
+	}))
+	srv.TLS = &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  clientCAs,
+	}
+	srv.StartTLS()
+	defer srv.Close()
+	caFile := filepath.Join(dir, "ca.pem")
+	writePEM(t, caFile, "CERTIFICATE", srv.Certificate().Raw)
+
+	tests := []struct {
+		name    string
+		set     map[string]interface{}
+		success bool
+	}{
+		{"mTLS", map[string]interface{}{"caFile": caFile, "certFile": filepath.Join(dir, "client.crt"), "keyFile": filepath.Join(dir, "client.key")}, true},
+		{"no client cert", map[string]interface{}{"caFile": caFile}, false},
+		{"unknown CA", map[string]interface{}{"certFile": filepath.Join(dir, "client.crt"), "keyFile": filepath.Join(dir, "client.key")}, false},
+		{"insecureSkipVerify", map[string]interface{}{"insecureSkipVerify": true, "certFile": filepath.Join(dir, "client.crt"), "keyFile": filepath.Join(dir, "client.key")}, true},
+	}
+	for _, tt := range tests {
+		prop := opt.New()
+		prop.Set("uri", srv.URL)
+		prop.Set("retries", 0)
+		for key, val := range tt.set {
+			prop.Set(key, val)
+		}
+		conn, err := (&restDriver{}).Connect(nil, prop)
+		if !tt.success {
+			if err == nil {
+				conn.Close()
+				t.Fatalf("%s: connection must fail", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		conn.Close()
+	}
+
+	// CA bundle without certificate
+	writeFileContent(t, filepath.Join(dir, "empty.pem"), "no certificate")
+	prop := opt.New()
+	prop.Set("uri", srv.URL)
+	prop.Set("caFile", filepath.Join(dir, "empty.pem"))
+	if _, err := (&restDriver{}).Connect(nil, prop); err == nil {
+		t.Fatalf("CA file without certificate must be rejected")
+	}
+}
+
+func writeFileContent(t *testing.T, fileName, content string) {
+	t.Helper()
+	if err := ioutil.WriteFile(fileName, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// server recording request headers
+type headerServer struct {
+	mu     sync.Mutex
+	header http.Header
+	host   string
+}
+
+func (hs *headerServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	hs.mu.Lock()
+	hs.header = r.Header.Clone()
+	hs.host = r.URL.Host
+	hs.mu.Unlock()
+	w.Write([]byte(`{"a": 1}`))
+}
+
+func (hs *headerServer) get(key string) string {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	return hs.header.Get(key)
+}
+
+func TestAuthHeaders(t *testing.T) {
+	hs := &headerServer{}
+	srv := httptest.NewTLSServer(hs)
+	defer srv.Close()
+
+	os.Setenv("OPT_TEST_TOKEN", "token1")
+	os.Setenv("OPT_TEST_TENANT", "tenant1")
+	defer os.Unsetenv("OPT_TEST_TOKEN")
+	defer os.Unsetenv("OPT_TEST_TENANT")
+
+	prop := opt.New()
+	prop.Set("uri", srv.URL)
+	prop.Set("insecureSkipVerify", true)
+	prop.Set("token", "secret://env/OPT_TEST_TOKEN")
+	prop.Set("username", "user")
+	prop.Set("password", "pass")
+	prop.Set("headers", map[string]interface{}{
+		"X-Tenant":  "secret://env/OPT_TEST_TENANT",
+		"X-Version": "1",
+	})
+	conn, err := (&restDriver{}).Connect(nil, prop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// bearer token takes precedence over basic authentication
+	if v := hs.get("Authorization"); v != "Bearer token1" {
+		t.Fatalf("Expecting bearer token, got %q", v)
+	}
+	if hs.get("X-Tenant") != "tenant1" || hs.get("X-Version") != "1" {
+		t.Fatalf("Custom headers not sent, got %q, %q", hs.get("X-Tenant"), hs.get("X-Version"))
+	}
+}
+
+func TestTokenFile(t *testing.T) {
+	hs := &headerServer{}
+	srv := httptest.NewTLSServer(hs)
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "opt-rest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "token")
+	writeFileContent(t, tokenFile, "token1\n")
+
+	prop := opt.New()
+	prop.Set("uri", srv.URL)
+	prop.Set("insecureSkipVerify", true)
+	prop.Set("tokenFile", tokenFile)
+	conn, err := (&restDriver{}).Connect(nil, prop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if v := hs.get("Authorization"); v != "Bearer token1" {
+		t.Fatalf("Expecting token from file, got %q", v)
+	}
+
+	// rotated token is read again
+	writeFileContent(t, tokenFile, "token2\n")
+	modTime := time.Now().Add(time.Minute)
+	if err := os.Chtimes(tokenFile, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if v := hs.get("Authorization"); v != "Bearer token2" {
+		t.Fatalf("Expecting rotated token, got %q", v)
+	}
+}
+
+func TestProxy(t *testing.T) {
+	hs := &headerServer{}
+	proxy := httptest.NewServer(hs)
+	defer proxy.Close()
+
+	prop := opt.New()
+	prop.Set("uri", "http://config.example.com/app")
+	prop.Set("proxy", proxy.URL)
+	conn, err := (&restDriver{}).Connect(nil, prop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	if hs.host != "config.example.com" {
+		t.Fatalf("Request must be sent through proxy, got host %q", hs.host)
+	}
+}
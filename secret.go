@@ -0,0 +1,140 @@
+package opt
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// SecretPrefix marks a value as secret reference, e.g.
+// secret://file/run/secrets/db_pass or secret://env/DB_PASS
+const SecretPrefix = "secret://"
+
+var (
+	secretsMu sync.RWMutex
+	secrets   = make(map[string]SecretResolver)
+)
+
+// SecretResolver resolves secret reference to its actual value
+type SecretResolver interface {
+	Resolve(ref string) (string, error)
+}
+
+// SecretResolverFunc is an adapter to use ordinary function as SecretResolver
+type SecretResolverFunc func(ref string) (string, error)
+
+// Resolve calls f(ref)
+func (f SecretResolverFunc) Resolve(ref string) (string, error) {
+	return f(ref)
+}
+
+// ExecSecretResolver runs command to obtain the secret. It is not registered
+// by default, since any loaded configuration value could run a command. Enable
+// it explicitly with RegisterSecret("exec", opt.ExecSecretResolver).
+var ExecSecretResolver SecretResolver = SecretResolverFunc(resolveExecSecret)
+
+// register default resolvers
+func init() {
+	RegisterSecret("file", SecretResolverFunc(resolveFileSecret))
+	RegisterSecret("env", SecretResolverFunc(resolveEnvSecret))
+}
+
+// RegisterSecret makes a secret resolver available by the provided name.
+// If RegisterSecret is called twice with the same name or if resolver is nil,
+// it panics.
+func RegisterSecret(name string, resolver SecretResolver) {
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	if resolver == nil {
+		panic("alert: RegisterSecret resolver is nil")
+	}
+	if _, dup := secrets[name]; dup {
+		panic("alert: RegisterSecret called twice for resolver " + name)
+	}
+	secrets[name] = resolver
+}
+
+// SecretResolvers returns a sorted list of the names of the registered resolvers.
+func SecretResolvers() []string {
+	secretsMu.RLock()
+	defer secretsMu.RUnlock()
+	var list []string
+	for name := range secrets {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// IsSecretRef returns true if value is a secret reference
+func IsSecretRef(value string) bool {
+	return strings.HasPrefix(value, SecretPrefix)
+}
+
+// ResolveSecret returns the value referenced by secret://<resolver>/<ref>.
+// Value which is not a secret reference is returned as is.
+func ResolveSecret(value string) (string, error) {
+	if !IsSecretRef(value) {
+		return value, nil
+	}
+
+	name := strings.TrimPrefix(value, SecretPrefix)
+	ref := ""
+	if i := strings.IndexByte(name, '/'); i >= 0 {
+		name, ref = name[:i], name[i+1:]
+	}
+
+	secretsMu.RLock()
+	resolver, ok := secrets[name]
+	secretsMu.RUnlock()
+	if !ok {
+		return "", errors.Errorf("can not find secret resolver %s, forget to register?", name)
+	}
+
+	secret, err := resolver.Resolve(ref)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to resolve secret %s", name)
+	}
+	return secret, nil
+}
+
+// file reference is an absolute path, i.e. secret://file/run/secrets/db_pass
+// reads /run/secrets/db_pass. Trailing new-line is removed.
+func resolveFileSecret(ref string) (string, error) {
+	if ref == "" {
+		return "", errors.New("empty file reference")
+	}
+	content, err := ioutil.ReadFile("/" + ref)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
+
+// env reference is the name of environment variable
+func resolveEnvSecret(ref string) (string, error) {
+	val, ok := os.LookupEnv(ref)
+	if !ok {
+		return "", errors.Errorf("environment variable %s not set", ref)
+	}
+	return val, nil
+}
+
+// exec reference is an absolute command path followed by its arguments,
+// i.e. secret://exec/usr/local/bin/vault-get db. Trailing new-line is removed.
+func resolveExecSecret(ref string) (string, error) {
+	args := strings.Fields(ref)
+	if len(args) == 0 {
+		return "", errors.New("empty exec reference")
+	}
+	out, err := exec.Command("/"+args[0], args[1:]...).Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(out), "\r\n"), nil
+}
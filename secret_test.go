@@ -0,0 +1,77 @@
+package opt
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSecret(t *testing.T) {
+	os.Setenv("OPT_TEST_SECRET", "env-pass")
+	defer os.Unsetenv("OPT_TEST_SECRET")
+
+	f, err := ioutil.TempFile("", "opt-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("file-pass\n")
+	f.Close()
+
+	op := New()
+	op.Set("db.password", "secret://env/OPT_TEST_SECRET")
+	op.Set("db.dsnPass", "secret://file"+f.Name())
+	op.Set("db.missing", "secret://env/OPT_TEST_NOT_EXISTS")
+	op.Set("db.unknown", "secret://vault/db")
+	op.Set("db.user", "postgres")
+
+	if v, err := op.GetSecret("db.password"); err != nil || v != "env-pass" {
+		t.Fatalf("Expecting env-pass, got %q %v", v, err)
+	}
+	if v, err := op.GetSecret("db.dsnPass"); err != nil || v != "file-pass" {
+		t.Fatalf("Expecting file-pass, got %q %v", v, err)
+	}
+	if v, err := op.GetSecret("db.user"); err != nil || v != "postgres" {
+		t.Fatalf("Expecting postgres, got %q %v", v, err)
+	}
+	if v := op.GetString("db.password", ""); v != "secret://env/OPT_TEST_SECRET" {
+		t.Fatalf("GetString must not resolve secret, got %q", v)
+	}
+	if _, err := op.GetSecret("db.unknown"); err == nil {
+		t.Fatalf("Unknown resolver must return error")
+	}
+	if _, err := op.GetSecret("db.missing"); err == nil {
+		t.Fatalf("Missing environment variable must return error")
+	}
+
+	// escape sequences are not replaced in literal value and reference
+	dir, err := ioutil.TempDir("", "opt-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	escaped := filepath.Join(dir, `pass\tword`)
+	if err := ioutil.WriteFile(escaped, []byte("escaped-pass"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	op.Set("db.literal", `pa\nss\\word`)
+	op.Set("db.escaped", "secret://file"+escaped)
+	if v, err := op.GetSecret("db.literal"); err != nil || v != `pa\nss\\word` {
+		t.Fatalf("Literal secret must not be unescaped, got %q %v", v, err)
+	}
+	if v, err := op.GetSecret("db.escaped"); err != nil || v != "escaped-pass" {
+		t.Fatalf("Expecting escaped-pass, got %q %v", v, err)
+	}
+
+	if _, err := op.GetSecret("db.notExists"); err == nil {
+		t.Fatalf("Missing key must return error")
+	}
+
+	// exec is not registered by default
+	op.Set("log.level", "secret://exec/bin/echo executed")
+	if _, err := op.GetSecret("log.level"); err == nil {
+		t.Fatalf("exec resolver must not be registered by default")
+	}
+	t.Logf("Resolvers: %v", SecretResolvers())
+}
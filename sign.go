@@ -0,0 +1,117 @@
+package opt
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ErrInvalidSignature is returned when configuration signature does not match
+var ErrInvalidSignature = errors.New("invalid configuration signature")
+
+// ErrLinkedFile is returned when signed configuration contains "@file" link.
+// Linked file is loaded lazily by Get and ExpandAll, it is not covered
+// by the signature, so such configuration is rejected.
+var ErrLinkedFile = errors.New("linked file in signed configuration")
+
+// Canonical returns canonical form of the options, i.e. compact JSON with
+// sorted keys. Signature is computed from this form, so the same options
+// read from JSON or HJSON (with comments) produce the same signature.
+func (o *Options) Canonical() ([]byte, error) {
+	if o.rlock() {
+		defer o.RUnlock()
+	}
+
+	return json.Marshal(o.options)
+}
+
+// Sign creates base64 encoded Ed25519 signature of the options canonical form.
+// Options linking other file returns ErrLinkedFile.
+func Sign(o *Options, key ed25519.PrivateKey) (string, error) {
+	if len(key) != ed25519.PrivateKeySize {
+		return "", errors.New("invalid ed25519 private key size")
+	}
+	if o.hasLink() {
+		return "", ErrLinkedFile
+	}
+	msg, err := o.Canonical()
+	if err != nil {
+		return "", err
+	}
+	sig := ed25519.Sign(key, msg)
+
+	return base64.StdEncoding.EncodeToString(sig), nil
+}
+
+// Verify checks base64 encoded signature against the options canonical form.
+// Options linking other file returns ErrLinkedFile even if the signature is valid.
+func Verify(o *Options, key ed25519.PublicKey, signature string) error {
+	if o == nil {
+		return errors.New("options is nil")
+	}
+	if len(key) != ed25519.PublicKeySize {
+		return errors.New("invalid ed25519 public key size")
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(signature))
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	msg, err := o.Canonical()
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(key, msg, sig) {
+		return ErrInvalidSignature
+	}
+	if o.hasLink() {
+		return ErrLinkedFile
+	}
+
+	return nil
+}
+
+// hasLink returns true if any value is "@file" link
+func (o *Options) hasLink() bool {
+	if o.rlock() {
+		defer o.RUnlock()
+	}
+
+	return hasLink(o.options)
+}
+
+func hasLink(m map[string]interface{}) bool {
+	for _, val := range m {
+		switch v := val.(type) {
+		case string:
+			if strings.HasPrefix(v, "@") {
+				return true
+			}
+		case map[string]interface{}:
+			if hasLink(v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ParsePublicKey decodes base64 encoded Ed25519 public key.
+// The key may be given as secret reference, e.g. secret://file/etc/app/config.pub
+func ParsePublicKey(s string) (ed25519.PublicKey, error) {
+	s, err := ResolveSecret(s)
+	if err != nil {
+		return nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode public key")
+	}
+	if len(key) != ed25519.PublicKeySize {
+		return nil, errors.New("invalid ed25519 public key size")
+	}
+
+	return ed25519.PublicKey(key), nil
+}
@@ -0,0 +1,69 @@
+package opt
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"testing"
+)
+
+func TestSignVerify(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	op, err := FromText(`{"server": {"port": 8080, "host": "localhost"}, "debug": true}`, FormatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := Sign(op, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("Signature: %s", sig)
+
+	// same document written in HJSON with comments
+	hop, err := FromText(`{
+		# enable debug
+		debug: true
+		server: {
+			host: localhost
+			port: 8080
+		}
+	}`, FormatHJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ParsePublicKey(base64.StdEncoding.EncodeToString(pub))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Verify(hop, key, sig+"\n"); err != nil {
+		t.Fatalf("Verification of canonical form failed: %v", err)
+	}
+
+	// tampered configuration
+	hop.Set("server.port", 9090)
+	if err := Verify(hop, key, sig); err != ErrInvalidSignature {
+		t.Fatalf("Expecting ErrInvalidSignature, got %v", err)
+	}
+	if err := Verify(op, key, "not-base64!"); err != ErrInvalidSignature {
+		t.Fatalf("Expecting ErrInvalidSignature, got %v", err)
+	}
+
+	// linked file is not covered by the signature
+	linked := op.Clone()
+	linked.Set("server", "@server.hjson")
+	if _, err := Sign(linked, priv); err != ErrLinkedFile {
+		t.Fatalf("Expecting ErrLinkedFile on sign, got %v", err)
+	}
+	msg, err := linked.Canonical()
+	if err != nil {
+		t.Fatal(err)
+	}
+	linkedSig := base64.StdEncoding.EncodeToString(ed25519.Sign(priv, msg))
+	if err := Verify(linked, key, linkedSig); err != ErrLinkedFile {
+		t.Fatalf("Expecting ErrLinkedFile on verify, got %v", err)
+	}
+}
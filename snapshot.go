@@ -0,0 +1,119 @@
+package opt
+
+import (
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+// ErrFrozen is returned when modifying frozen options
+var ErrFrozen = errors.New("options is frozen")
+
+// Snapshot returns immutable view of current options. Modification of the
+// original options does not affect the snapshot, since maps are copied on write.
+func (o *Options) Snapshot() *Options {
+	if o.rlock() {
+		defer o.RUnlock()
+	}
+
+	return &Options{
+		filePath: o.filePath,
+		frozen:   1,
+		options:  o.options,
+	}
+}
+
+// Clone returns deep copy of the options. The clone is always writable.
+func (o *Options) Clone() *Options {
+	if o.rlock() {
+		defer o.RUnlock()
+	}
+
+	return &Options{
+		filePath: o.filePath,
+		options:  copyValue(o.options).(map[string]interface{}),
+	}
+}
+
+// Freeze marks options as immutable and returns it. Frozen options may be
+// shared between goroutines and read without locking. Set, Assign and Merge
+// on frozen options panic, Parse returns ErrFrozen. Options returned by Get
+// and GetObjectArray are writable copy-on-write views, so only the frozen
+// options itself can not be modified. Use Clone to obtain writable copy.
+func (o *Options) Freeze() *Options {
+	if o.isFrozen() {
+		return o
+	}
+	o.Lock()
+	defer o.Unlock()
+
+	atomic.StoreInt32(&o.frozen, 1)
+	return o
+}
+
+// IsFrozen returns true if options is immutable
+func (o *Options) IsFrozen() bool {
+	return o.isFrozen()
+}
+
+func (o *Options) isFrozen() bool {
+	return atomic.LoadInt32(&o.frozen) == 1
+}
+
+// view returns writable options sharing data with o.
+// Modification is copy-on-write, so o is not affected.
+func (o *Options) view() *Options {
+	if o.rlock() {
+		defer o.RUnlock()
+	}
+
+	return &Options{
+		filePath: o.filePath,
+		options:  o.options,
+	}
+}
+
+// rlock acquires read lock and returns true. Frozen options is never
+// modified, so the lock is not needed and false is returned.
+func (o *Options) rlock() bool {
+	if o.isFrozen() {
+		return false
+	}
+	o.RLock()
+	return true
+}
+
+func (o *Options) mustNotFrozen(op string) {
+	if o.isFrozen() {
+		panic("alert: " + op + " called on frozen options")
+	}
+}
+
+// copyMap returns shallow copy of the map
+func copyMap(m map[string]interface{}) map[string]interface{} {
+	nm := make(map[string]interface{}, len(m))
+	for key, val := range m {
+		nm[key] = val
+	}
+	return nm
+}
+
+// copyValue returns deep copy of maps and arrays
+func copyValue(val interface{}) interface{} {
+	switch v := val.(type) {
+	case map[string]interface{}:
+		nm := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			nm[key] = copyValue(item)
+		}
+		return nm
+	case []interface{}:
+		na := make([]interface{}, len(v))
+		for i, item := range v {
+			na[i] = copyValue(item)
+		}
+		return na
+	default:
+		return val
+	}
+}
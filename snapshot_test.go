@@ -0,0 +1,102 @@
+package opt
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	op, err := FromText(`{"server": {"port": 8080, "host": "localhost"}, "tags": ["a", "b"]}`, FormatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snap := op.Snapshot()
+	if !snap.IsFrozen() || op.IsFrozen() {
+		t.Fatalf("Only snapshot must be frozen")
+	}
+
+	// modification of original must not affect snapshot
+	op.Set("server.port", 9090)
+	if v := snap.GetInt("server.port", 0); v != 8080 {
+		t.Fatalf("Snapshot changed, expecting 8080 got %d", v)
+	}
+
+	// modification of child must not affect parent
+	server := op.Get("server")
+	server.Set("host", "example.com")
+	if v := op.GetString("server.host", ""); v != "localhost" {
+		t.Fatalf("Parent changed, expecting localhost got %s", v)
+	}
+
+	// child of snapshot is writable copy-on-write view
+	child := snap.Get("server")
+	child.Set("port", 1)
+	if child.IsFrozen() || snap.GetInt("server.port", 0) != 8080 {
+		t.Fatalf("Child must be writable without affecting snapshot")
+	}
+	if err := child.Parse("host=example.com"); err != nil || snap.GetString("server.host", "") != "localhost" {
+		t.Fatalf("Parse on child must not affect snapshot, %v", err)
+	}
+
+	// returned objects are copies
+	tags, _ := snap.GetObject("tags")
+	tags.([]interface{})[0] = "x"
+	serverMap, _ := snap.GetObject("server")
+	serverMap.(map[string]interface{})["port"] = 1
+	if v := snap.GetStringArray("tags"); v[0] != "a" || snap.GetInt("server.port", 0) != 8080 {
+		t.Fatalf("GetObject must return copy, got %v", v)
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatalf("Set on frozen options must panic")
+			}
+		}()
+		snap.Set("server.port", 1)
+	}()
+	if err := snap.Parse("a=b"); err != ErrFrozen {
+		t.Fatalf("Expecting ErrFrozen, got %v", err)
+	}
+
+	// expanding frozen options returns the map without modifying it
+	if m := snap.Get("server").ExpandAll(); m["port"] != float64(8080) {
+		t.Fatalf("Unexpected expanded map %v", m)
+	}
+
+	// clone is writable deep copy
+	clone := snap.Clone()
+	clone.Set("server.port", 1)
+	if clone.IsFrozen() || snap.GetInt("server.port", 0) != 8080 {
+		t.Fatalf("Clone must be writable and independent")
+	}
+	if clone.GetInt("server.port", 0) != 1 {
+		t.Fatalf("Clone value not set")
+	}
+}
+
+func TestSnapshotConcurrent(t *testing.T) {
+	op := New()
+	op.Set("log.level", "info")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for k := 0; k < 1000; k++ {
+				op.Set("log.level", "debug")
+				op.Get("log").Set("file", "app.log")
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for k := 0; k < 1000; k++ {
+				child := op.Get("log")
+				child.GetString("level", "")
+				op.Snapshot().GetString("log.level", "")
+			}
+		}()
+	}
+	wg.Wait()
+}
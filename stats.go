@@ -0,0 +1,125 @@
+package opt
+
+import (
+	"expvar"
+	"time"
+)
+
+// Stats contains reload statistics of Configurator
+type Stats struct {
+	Driver          string
+	ReloadAttempts  int64
+	ReloadSuccesses int64
+	ReloadFailures  int64
+	ErrorsReported  int64
+	LastSuccess     time.Time
+	LastFailure     time.Time
+	LastError       string
+	// FailingSince is the time of first failure after last success,
+	// zero if the last reload succeeded. Errors of reading the source
+	// reported by the driver (e.g. failed polling) are counted as failure
+	// until the driver reports the source is readable again.
+	FailingSince time.Time
+	Hash         string
+	LoadLatency  time.Duration
+	StoreLatency time.Duration
+}
+
+// Stale returns true if reload has been failing for more than d,
+// i.e. the application runs on stale configuration
+func (s Stats) Stale(d time.Duration) bool {
+	return !s.FailingSince.IsZero() && time.Since(s.FailingSince) > d
+}
+
+// Stats returns reload statistics
+func (cfg *Configurator) Stats() Stats {
+	cfg.statMu.Lock()
+	st := cfg.stats
+	sourceFailing := cfg.sourceFailingSince
+	cfg.statMu.Unlock()
+
+	if !sourceFailing.IsZero() && (st.FailingSince.IsZero() || sourceFailing.Before(st.FailingSince)) {
+		st.FailingSince = sourceFailing
+	}
+
+	if lastCfg := cfg.config(); lastCfg != nil {
+		st.Hash = lastCfg.Hash()
+	}
+	return st
+}
+
+// Publish exports Stats as expvar variable with given name.
+// Like expvar.Publish, it panics if the name is already registered.
+func (cfg *Configurator) Publish(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return cfg.Stats()
+	}))
+}
+
+// recordReload counts reload attempt result
+func (cfg *Configurator) recordReload(err error) {
+	cfg.statMu.Lock()
+	defer cfg.statMu.Unlock()
+
+	now := time.Now()
+	cfg.stats.ReloadAttempts++
+	if err == nil {
+		cfg.stats.ReloadSuccesses++
+		cfg.stats.LastSuccess = now
+		cfg.stats.FailingSince = time.Time{}
+		cfg.sourceFailingSince = time.Time{}
+		return
+	}
+	cfg.stats.ReloadFailures++
+	cfg.stats.LastFailure = now
+	cfg.stats.LastError = err.Error()
+	if cfg.stats.FailingSince.IsZero() {
+		cfg.stats.FailingSince = now
+	}
+}
+
+// recordError counts error reported by the connector. Failure of reading
+// the source is counted as failed reload attempt.
+func (cfg *Configurator) recordError(err error) {
+	cfg.statMu.Lock()
+	defer cfg.statMu.Unlock()
+
+	cfg.stats.ErrorsReported++
+	cfg.stats.LastError = err.Error()
+	if !isSourceError(err) {
+		return
+	}
+	now := time.Now()
+	cfg.stats.ReloadAttempts++
+	cfg.stats.ReloadFailures++
+	cfg.stats.LastFailure = now
+	if cfg.sourceFailingSince.IsZero() {
+		cfg.sourceFailingSince = now
+	}
+}
+
+// recordSourceOK clears source failure reported by the connector
+func (cfg *Configurator) recordSourceOK() {
+	cfg.statMu.Lock()
+	defer cfg.statMu.Unlock()
+
+	cfg.sourceFailingSince = time.Time{}
+}
+
+// isSourceError returns true if driver failed to read the source. Errors
+// of reload are already counted and watch errors do not make config stale.
+func isSourceError(err error) bool {
+	de, ok := err.(*DriverError)
+	return ok && de.Op != "reload" && de.Op != "watch"
+}
+
+func (cfg *Configurator) recordLatency(load bool, d time.Duration) {
+	cfg.statMu.Lock()
+	defer cfg.statMu.Unlock()
+
+	if load {
+		cfg.stats.LoadLatency = d
+	} else {
+		cfg.stats.StoreLatency = d
+	}
+}
@@ -0,0 +1,64 @@
+package opt
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"testing"
+)
+
+func TestConfiguratorStats(t *testing.T) {
+	cfg, conn := newTestConfigurator(t, `{"log": {"level": "info"}}`)
+	defer cfg.Close()
+
+	if err := conn.change(`{"log": {"level": "debug"}}`); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.change(`{"log": `); err == nil {
+		t.Fatalf("Invalid configuration must be rejected")
+	}
+
+	st := cfg.Stats()
+	if st.Driver != "test" || st.ReloadAttempts != 3 || st.ReloadSuccesses != 2 || st.ReloadFailures != 1 {
+		t.Fatalf("Unexpected stats %+v", st)
+	}
+	if st.FailingSince.IsZero() || st.LastError == "" || st.Hash != cfg.config().Hash() {
+		t.Fatalf("Unexpected stats %+v", st)
+	}
+	if st.Stale(0) == false {
+		t.Fatalf("Configuration must be stale")
+	}
+
+	if err := conn.change(`{"log": {"level": "info"}}`); err != nil {
+		t.Fatal(err)
+	}
+	if st := cfg.Stats(); !st.FailingSince.IsZero() || st.Stale(0) {
+		t.Fatalf("Configuration must not be stale, got %+v", st)
+	}
+
+	// expvar names are global, use unique name so the test can be repeated
+	name := fmt.Sprintf("opt_test_%p", cfg)
+	cfg.Publish(name)
+	if v := expvar.Get(name); v == nil || v.String() == "" {
+		t.Fatalf("Stats must be published")
+	}
+}
+
+func TestConfiguratorStatsSourceError(t *testing.T) {
+	cfg, conn := newTestConfigurator(t, `{"log": {"level": "info"}}`)
+	defer cfg.Close()
+
+	for i := 0; i < 5; i++ {
+		conn.Report(&DriverError{Driver: "test", Op: "get config", Err: errors.New("connection refused")})
+	}
+	st := cfg.Stats()
+	if !st.Stale(0) || st.ReloadFailures != 5 || st.ErrorsReported != 5 || st.LastFailure.IsZero() {
+		t.Fatalf("Source errors must make configuration stale, got %+v", st)
+	}
+
+	// source is readable again, configuration is unchanged
+	conn.ReportSuccess()
+	if st := cfg.Stats(); st.Stale(0) {
+		t.Fatalf("Configuration must not be stale, got %+v", st)
+	}
+}
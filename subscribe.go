@@ -0,0 +1,167 @@
+package opt
+
+import (
+	"sort"
+	"strings"
+)
+
+// size of subscription channel buffer
+const subscriptionBufferSize = 16
+
+// Subscription receives changes of keys matching its pattern.
+// Pattern is key path separated by dot, `*` matches any single segment.
+// Pattern also matches all keys below it, e.g. `log` matches `log.level`.
+type Subscription struct {
+	pattern []string
+	fn      func(c Change)
+	ch      chan Change
+	closed  bool
+
+	// C delivers changes for channel based subscription, nil for callback.
+	// If the channel buffer is full, the change is dropped.
+	C <-chan Change
+}
+
+// Subscribe returns channel based subscription for changes of keys matching pattern
+func (cfg *Configurator) Subscribe(pattern string) *Subscription {
+	ch := make(chan Change, subscriptionBufferSize)
+	s := &Subscription{
+		pattern: strings.Split(pattern, "."),
+		ch:      ch,
+		C:       ch,
+	}
+	cfg.addSubscription(s)
+
+	return s
+}
+
+// OnChange calls fn for every change of keys matching pattern. fn is called
+// synchronously after new configuration is applied, so it must not call
+// methods of Configurator other than Get, Subscribe, OnChange and Unsubscribe.
+func (cfg *Configurator) OnChange(pattern string, fn func(c Change)) *Subscription {
+	s := &Subscription{
+		pattern: strings.Split(pattern, "."),
+		fn:      fn,
+	}
+	cfg.addSubscription(s)
+
+	return s
+}
+
+// Unsubscribe removes the subscription and closes its channel
+func (cfg *Configurator) Unsubscribe(s *Subscription) {
+	cfg.subMu.Lock()
+	defer cfg.subMu.Unlock()
+
+	for i, v := range cfg.subs {
+		if v == s {
+			cfg.subs = append(cfg.subs[:i:i], cfg.subs[i+1:]...)
+			s.closed = true
+			if s.ch != nil {
+				close(s.ch)
+			}
+			return
+		}
+	}
+}
+
+func (cfg *Configurator) addSubscription(s *Subscription) {
+	cfg.subMu.Lock()
+	defer cfg.subMu.Unlock()
+
+	cfg.subs = append(cfg.subs, s)
+}
+
+// notify subscribers about changes between old and new configuration
+func (cfg *Configurator) notify(oldCfg, newCfg *Options) {
+	cfg.subMu.Lock()
+	subs := cfg.subs
+	cfg.subMu.Unlock()
+
+	if len(subs) == 0 {
+		return
+	}
+	changes := Diff(oldCfg, newCfg)
+	for _, c := range changes {
+		key := strings.Split(c.Key, ".")
+		for _, s := range subs {
+			for _, sc := range s.changesFor(c, key) {
+				if s.fn != nil {
+					s.fn(sc)
+					continue
+				}
+
+				// channel may be closed by Unsubscribe
+				cfg.subMu.Lock()
+				if !s.closed {
+					select {
+					case s.ch <- sc:
+					default:
+					}
+				}
+				cfg.subMu.Unlock()
+			}
+		}
+	}
+}
+
+// changesFor returns changes delivered to the subscription. Change of key above
+// the pattern (e.g. whole section added or removed) is narrowed to the keys
+// matched by the pattern, taking the values from the nested objects.
+func (s *Subscription) changesFor(c Change, key []string) []Change {
+	n := len(key)
+	if n > len(s.pattern) {
+		n = len(s.pattern)
+	}
+	for i, p := range s.pattern[:n] {
+		if p != "*" && p != key[i] {
+			return nil
+		}
+	}
+	if len(key) >= len(s.pattern) {
+		return []Change{c}
+	}
+
+	changes := []Change{}
+	narrowChange(c.Key, s.pattern[len(key):], c.OldValue, c.NewValue,
+		c.Kind != KeyAdded, c.Kind != KeyRemoved, &changes)
+	return changes
+}
+
+// narrowChange follows the rest of pattern in old and new value
+func narrowChange(key string, pattern []string, oldVal, newVal interface{}, hasOld, hasNew bool, changes *[]Change) {
+	if len(pattern) == 0 {
+		switch {
+		case hasOld && hasNew:
+			if !DeepEqual(oldVal, newVal) {
+				*changes = append(*changes, Change{Key: key, Kind: KeyModified, OldValue: oldVal, NewValue: newVal})
+			}
+		case hasOld:
+			*changes = append(*changes, Change{Key: key, Kind: KeyRemoved, OldValue: oldVal})
+		case hasNew:
+			*changes = append(*changes, Change{Key: key, Kind: KeyAdded, NewValue: newVal})
+		}
+		return
+	}
+
+	oldMap, _ := oldVal.(map[string]interface{})
+	newMap, _ := newVal.(map[string]interface{})
+	names := []string{pattern[0]}
+	if pattern[0] == "*" {
+		names = names[:0]
+		for name := range oldMap {
+			names = append(names, name)
+		}
+		for name := range newMap {
+			if _, ok := oldMap[name]; !ok {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+	}
+	for _, name := range names {
+		ov, okOld := oldMap[name]
+		nv, okNew := newMap[name]
+		narrowChange(key+"."+name, pattern[1:], ov, nv, okOld, okNew, changes)
+	}
+}
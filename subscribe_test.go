@@ -0,0 +1,85 @@
+package opt
+
+import (
+	"testing"
+)
+
+func TestSubscribe(t *testing.T) {
+	cfg, conn := newTestConfigurator(t, `{"log": {"level": "info", "file": "app.log"}, "db": {"port": 5432}}`)
+	defer cfg.Close()
+
+	sub := cfg.Subscribe("log.level")
+	all := cfg.Subscribe("*.port")
+	changes := []Change{}
+	cb := cfg.OnChange("log", func(c Change) {
+		changes = append(changes, c)
+	})
+
+	if err := conn.change(`{"log": {"level": "debug", "file": "app2.log"}, "db": {"port": 5433}}`); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case c := <-sub.C:
+		if c.Key != "log.level" || c.OldValue != "info" || c.NewValue != "debug" {
+			t.Fatalf("Unexpected change %+v", c)
+		}
+	default:
+		t.Fatalf("Change of log.level not delivered")
+	}
+	if len(sub.C) != 0 {
+		t.Fatalf("Only log.level must be delivered")
+	}
+	if c := <-all.C; c.Key != "db.port" {
+		t.Fatalf("Expecting db.port, got %+v", c)
+	}
+	if len(changes) != 2 {
+		t.Fatalf("Expecting 2 changes of log, got %+v", changes)
+	}
+
+	cfg.Unsubscribe(sub)
+	cfg.Unsubscribe(cb)
+	if _, ok := <-sub.C; ok {
+		t.Fatalf("Channel must be closed")
+	}
+	if err := conn.change(`{"log": {"level": "warn"}, "db": {"port": 5433}}`); err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 2 {
+		t.Fatalf("Callback must not be called after unsubscribe")
+	}
+}
+
+func TestSubscribeSection(t *testing.T) {
+	cfg, conn := newTestConfigurator(t, `{"db": {"port": 5432}}`)
+	defer cfg.Close()
+
+	sub := cfg.Subscribe("log.level")
+	all := cfg.Subscribe("*.level")
+
+	// whole section added
+	if err := conn.change(`{"db": {"port": 5432}, "log": {"level": "debug", "file": "app.log"}}`); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []*Subscription{sub, all} {
+		select {
+		case c := <-s.C:
+			if c.Key != "log.level" || c.Kind != KeyAdded || c.NewValue != "debug" {
+				t.Fatalf("Unexpected change %+v", c)
+			}
+		default:
+			t.Fatalf("Added log.level not delivered")
+		}
+	}
+
+	// whole section removed
+	if err := conn.change(`{"db": {"port": 5432}}`); err != nil {
+		t.Fatal(err)
+	}
+	if c := <-sub.C; c.Key != "log.level" || c.Kind != KeyRemoved || c.OldValue != "debug" {
+		t.Fatalf("Unexpected change %+v", c)
+	}
+	if len(sub.C) != 0 || len(all.C) != 1 {
+		t.Fatalf("Single change must be delivered")
+	}
+}
@@ -0,0 +1,145 @@
+package opt
+
+import (
+	"sync"
+	"time"
+)
+
+// ReloadPolicy controls how change events reported by the driver are turned
+// into reloads. Zero policy reloads synchronously on every event.
+type ReloadPolicy struct {
+	// Debounce delays reload until no event is received for the duration,
+	// events received within the window are coalesced into one reload.
+	Debounce time.Duration
+
+	// MinInterval is the minimum interval between reloads. Events received
+	// in between are coalesced and reloaded when the interval elapsed.
+	MinInterval time.Duration
+
+	// MaxBurst is number of reloads allowed back to back before
+	// MinInterval is enforced, default 1.
+	MaxBurst int
+}
+
+// throttle schedules reloads according to ReloadPolicy
+type throttle struct {
+	mu      sync.Mutex
+	running sync.WaitGroup
+	policy  ReloadPolicy
+	timer   *time.Timer
+	pending bool
+	closed  bool
+	tokens  float64
+	refill  time.Time
+}
+
+// SetReloadPolicy sets policy used for reloading configuration on
+// change events. With non zero policy reloads are done in background and
+// errors are reported through logger, error handler and Errors channel.
+func (cfg *Configurator) SetReloadPolicy(p ReloadPolicy) {
+	t := &cfg.throttle
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if p.MaxBurst < 1 {
+		p.MaxBurst = 1
+	}
+	t.policy = p
+	t.tokens = float64(p.MaxBurst)
+	t.refill = time.Now()
+}
+
+// ReloadPolicy returns current reload policy
+func (cfg *Configurator) ReloadPolicy() ReloadPolicy {
+	t := &cfg.throttle
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.policy
+}
+
+func (p ReloadPolicy) isZero() bool {
+	return p.Debounce <= 0 && p.MinInterval <= 0
+}
+
+// schedule reload, returns false if reload must be done synchronously
+func (t *throttle) schedule(reload func()) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.policy.isZero() {
+		return false
+	}
+	if t.closed {
+		return true
+	}
+
+	t.pending = true
+	delay := t.policy.Debounce
+	if d := t.wait(time.Now()); d > delay {
+		delay = d
+	}
+	if t.timer == nil {
+		t.timer = time.AfterFunc(delay, func() {
+			t.fire(reload)
+		})
+	} else {
+		t.timer.Reset(delay)
+	}
+	return true
+}
+
+// fire reloads if rate limit allows, otherwise reschedule
+func (t *throttle) fire(reload func()) {
+	t.mu.Lock()
+	if t.closed || !t.pending {
+		t.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	if d := t.wait(now); d > 0 {
+		t.timer.Reset(d)
+		t.mu.Unlock()
+		return
+	}
+	if t.policy.MinInterval > 0 {
+		t.tokens--
+	}
+	t.pending = false
+	t.running.Add(1)
+	t.mu.Unlock()
+
+	defer t.running.Done()
+	reload()
+}
+
+// wait refills tokens and returns duration until reload is allowed
+func (t *throttle) wait(now time.Time) time.Duration {
+	interval := t.policy.MinInterval
+	if interval <= 0 {
+		return 0
+	}
+	burst := float64(t.policy.MaxBurst)
+	t.tokens += float64(now.Sub(t.refill)) / float64(interval)
+	if t.tokens > burst {
+		t.tokens = burst
+	}
+	t.refill = now
+	if t.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - t.tokens) * float64(interval))
+}
+
+// stop cancels pending reload and waits for running reload
+func (t *throttle) stop() {
+	t.mu.Lock()
+	t.closed = true
+	t.pending = false
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+	t.mu.Unlock()
+
+	t.running.Wait()
+}
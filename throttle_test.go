@@ -0,0 +1,64 @@
+package opt
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReloadPolicy(t *testing.T) {
+	cfg, conn := newTestConfigurator(t, `{"log": {"level": "info"}}`)
+	defer cfg.Close()
+
+	logCfg := &testConfigurable{}
+	cfg.Register("log", logCfg)
+	cfg.SetReloadPolicy(ReloadPolicy{Debounce: 20 * time.Millisecond})
+
+	// burst of events is coalesced into one reload
+	for _, level := range []string{"debug", "warn", "error"} {
+		if err := conn.change(`{"log": {"level": "` + level + `"}}`); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	logCfg.mu.Lock()
+	count, level := logCfg.count, logCfg.last.GetString("level", "")
+	logCfg.mu.Unlock()
+	if count != 2 || level != "error" {
+		t.Fatalf("Expecting single reload with level error, got %d %s", count, level)
+	}
+
+	// reloads are rate limited
+	cfg.SetReloadPolicy(ReloadPolicy{MinInterval: 50 * time.Millisecond, MaxBurst: 1})
+	start := time.Now()
+	conn.change(`{"log": {"level": "info"}}`)
+	time.Sleep(10 * time.Millisecond)
+	conn.change(`{"log": {"level": "debug"}}`)
+	for cfg.Get("log").GetString("level", "") != "debug" {
+		if time.Since(start) > time.Second {
+			t.Fatalf("Configuration must be reloaded")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Fatalf("Reload must be delayed by MinInterval, got %v", d)
+	}
+	if st := cfg.Stats(); st.ReloadAttempts != 4 {
+		t.Fatalf("Expecting 4 reload attempts, got %d", st.ReloadAttempts)
+	}
+}
+
+func TestReloadPolicyClose(t *testing.T) {
+	cfg, conn := newTestConfigurator(t, `{"log": {"level": "info"}}`)
+	cfg.SetReloadPolicy(ReloadPolicy{Debounce: time.Millisecond})
+
+	conn.change(`{"log": {"level": "debug"}}`)
+	time.Sleep(time.Millisecond)
+	if err := cfg.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// events after close are ignored
+	if err := conn.change(`{"log": {"level": "warn"}}`); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,58 @@
+package opt
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// SetWriteThrough sets whether configuration changed by Set or Update
+// is saved to the source.
+func (cfg *Configurator) SetWriteThrough(enable bool) {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+
+	cfg.writeThrough = enable
+}
+
+// Set changes value of given key, see Update
+func (cfg *Configurator) Set(key string, val interface{}) error {
+	return cfg.Update(func(op *Options) {
+		op.Set(key, val)
+	})
+}
+
+// Update modifies copy of current configuration with fn, then applies it
+// to registered objects and subscribers the same way as reload. If write
+// through is enabled, the configuration is validated and stored to the
+// source first, so the change is applied only if storing succeeds.
+func (cfg *Configurator) Update(fn func(op *Options)) error {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+
+	lastCfg := cfg.config()
+	if lastCfg == nil {
+		return errors.New("configuration not loaded")
+	}
+	newCfg := lastCfg.Clone()
+	fn(newCfg)
+	if newCfg.EqualTo(lastCfg) {
+		return nil
+	}
+	if !cfg.writeThrough || cfg.conn == nil {
+		return cfg.apply(newCfg, false)
+	}
+
+	if err := cfg.validate(newCfg); err != nil {
+		return err
+	}
+	if err := cfg.store(context.Background(), newCfg); err != nil {
+		return errors.Wrap(err, "failed to store configuration")
+	}
+	if err := cfg.apply(newCfg, false); err != nil {
+		// restore the source, best effort
+		cfg.store(context.Background(), lastCfg)
+		return err
+	}
+	return nil
+}